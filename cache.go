@@ -0,0 +1,135 @@
+package unfurlist
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"golang.org/x/net/publicsuffix"
+)
+
+// mcFlagAlias marks memcached items holding cacheAlias instead of unfurlResult
+const mcFlagAlias = 1
+
+// cacheAlias is stored under cache key of url which resolves to another url
+// (i.e. short link redirecting to the article page) and points to the cache
+// key the result is stored under. It also keeps attributes specific for the
+// url alias was created for.
+type cacheAlias struct {
+	Key       string   `json:"key"`
+	FinalURL  string   `json:"final_url,omitempty"`
+	Redirects []string `json:"redirects,omitempty"`
+}
+
+// cacheGet looks up cached result for given url following alias if needed. It
+// returns result found and the url it was cached under.
+func (h *unfurlHandler) cacheGet(key string) (res *unfurlResult, primary string, ok bool) {
+	mc := h.Cache
+	if mc == nil {
+		return nil, "", false
+	}
+	it, err := mc.Get(mcKey(key))
+	if err != nil {
+		return nil, "", false
+	}
+	var alias *cacheAlias
+	if it.Flags&mcFlagAlias != 0 {
+		alias = new(cacheAlias)
+		if err := json.Unmarshal(it.Value, alias); err != nil || alias.Key == "" {
+			return nil, "", false
+		}
+		if it, err = mc.Get(mcKey(alias.Key)); err != nil || it.Flags&mcFlagAlias != 0 {
+			return nil, "", false
+		}
+	}
+	res = new(unfurlResult)
+	if err := json.Unmarshal(it.Value, res); err != nil {
+		return nil, "", false
+	}
+	if alias == nil {
+		return res, key, true
+	}
+	res.FinalURL, res.Redirects = alias.FinalURL, alias.Redirects
+	return res, alias.Key, true
+}
+
+// cacheSet saves result fetched for url key, which led to finalKey, under
+// primary url and creates aliases pointing to it for key and finalKey. Final
+// url and redirects are specific for url requested, so primary entry only
+// keeps them if primary is one of those urls.
+func (h *unfurlHandler) cacheSet(res *unfurlResult, primary, key, finalKey string) {
+	mc := h.Cache
+	if mc == nil {
+		return
+	}
+	// the final url reached directly has no redirects
+	direct := *res
+	direct.Redirects = nil
+	stored := *res
+	switch primary {
+	case key:
+	case finalKey:
+		stored = direct
+	default:
+		stored.FinalURL, stored.Redirects = "", nil
+	}
+	cdata, err := json.Marshal(&stored)
+	if err != nil {
+		return
+	}
+	h.Log.Printf("Cache update for %q", primary)
+	mc.Set(&memcache.Item{Key: mcKey(primary), Value: cdata})
+	h.cacheAlias(primary, res, key)
+	if finalKey != key {
+		h.cacheAlias(primary, &direct, finalKey)
+	}
+}
+
+// cacheAlias creates cache aliases pointing to primary url for all non-empty
+// urls provided except primary itself.
+func (h *unfurlHandler) cacheAlias(primary string, res *unfurlResult, aliases ...string) {
+	mc := h.Cache
+	if mc == nil {
+		return
+	}
+	adata, err := json.Marshal(cacheAlias{
+		Key:       primary,
+		FinalURL:  res.FinalURL,
+		Redirects: res.Redirects,
+	})
+	if err != nil {
+		return
+	}
+	seen := map[string]struct{}{primary: {}}
+	for _, k := range aliases {
+		if _, ok := seen[k]; ok || k == "" {
+			continue
+		}
+		seen[k] = struct{}{}
+		mc.Set(&memcache.Item{Key: mcKey(k), Value: adata, Flags: mcFlagAlias})
+	}
+}
+
+// trustedCanonical returns absolute canonical url declared by page fetched
+// from pageURL if it can be trusted to be used as a cache key: it must be
+// http/https url within the same registrable domain as pageURL, otherwise any
+// page could poison cache for arbitrary url by declaring it as canonical.
+func trustedCanonical(pageURL *url.URL, canonical string) (string, bool) {
+	if pageURL == nil || canonical == "" {
+		return "", false
+	}
+	cu, err := pageURL.Parse(canonical)
+	if err != nil || !validURL(cu.String()) {
+		return "", false
+	}
+	d1, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(pageURL.Hostname()))
+	if err != nil {
+		return "", false
+	}
+	d2, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(cu.Hostname()))
+	if err != nil || d1 != d2 {
+		return "", false
+	}
+	return cu.String(), true
+}
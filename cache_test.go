@@ -0,0 +1,137 @@
+package unfurlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+func TestTrustedCanonical(t *testing.T) {
+	page, _ := url.Parse("https://www.example.co.uk/news/1?utm_source=x")
+	testCases := []struct {
+		canonical string
+		want      string
+		ok        bool
+	}{
+		{"/news/1", "https://www.example.co.uk/news/1", true},
+		{"https://m.example.co.uk/news/1", "https://m.example.co.uk/news/1", true},
+		{"https://example.com/news/1", "", false},
+		{"https://other.co.uk/news/1", "", false},
+		{"ftp://www.example.co.uk/news/1", "", false},
+		{"", "", false},
+	}
+	for _, tc := range testCases {
+		got, ok := trustedCanonical(page, tc.canonical)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%q: got %q, %v, want %q, %v", tc.canonical, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestUnfurlist__cachedRedirects(t *testing.T) {
+	mc, stop := newFakeMemcache(t)
+	defer stop()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/s1", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article?ref=1", http.StatusFound)
+	})
+	mux.HandleFunc("/s2", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article?ref=2", http.StatusFound)
+	})
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Article</title><link rel="canonical" href="/article"></head></html>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	h := New(WithMemcache(mc), WithRedirectChain(true))
+
+	unfurl := func(link string) unfurlResult {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/?content="+url.QueryEscape(link), nil))
+		var res []unfurlResult
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+			t.Fatalf("unexpected response: %q", w.Body.String())
+		}
+		return res[0]
+	}
+	for _, link := range []string{"/s1", "/s2", "/s1", "/s2"} {
+		r := unfurl(srv.URL + link)
+		ref := link[len(link)-1:]
+		if r.Title != "Article" || r.FinalURL != srv.URL+"/article?ref="+ref ||
+			len(r.Redirects) != 1 || r.Redirects[0] != srv.URL+link {
+			t.Errorf("%s: unexpected result: final url %q, redirects %q", link, r.FinalURL, r.Redirects)
+		}
+	}
+	if r := unfurl(srv.URL + "/article"); r.Title != "Article" ||
+		strings.Contains(r.FinalURL, "ref=") || len(r.Redirects) != 0 {
+		t.Errorf("direct link: unexpected result: final url %q, redirects %q", r.FinalURL, r.Redirects)
+	}
+}
+
+// newFakeMemcache returns memcached client talking to in-process server which
+// only supports get and set commands, and function stopping the server
+func newFakeMemcache(t *testing.T) (*memcache.Client, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	items := make(map[string][2]string) // key to flags and value
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			f := strings.Fields(line)
+			switch {
+			case len(f) > 1 && (f[0] == "get" || f[0] == "gets"):
+				mu.Lock()
+				for _, k := range f[1:] {
+					if it, ok := items[k]; ok {
+						fmt.Fprintf(rw, "VALUE %s %s %d\r\n%s\r\n", k, it[0], len(it[1]), it[1])
+					}
+				}
+				mu.Unlock()
+				rw.WriteString("END\r\n")
+			case len(f) == 5 && f[0] == "set":
+				var n int
+				fmt.Sscan(f[4], &n)
+				data := make([]byte, n+2)
+				if _, err := io.ReadFull(rw, data); err != nil {
+					return
+				}
+				mu.Lock()
+				items[f[1]] = [2]string{f[2], string(data[:n])}
+				mu.Unlock()
+				rw.WriteString("STORED\r\n")
+			default:
+				rw.WriteString("ERROR\r\n")
+			}
+			rw.Flush()
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return memcache.New(ln.Addr().String()), func() { ln.Close() }
+}
@@ -0,0 +1,120 @@
+package unfurlist
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Canonicalizer rewrites url to its canonical form. Canonical url is used to
+// dedupe in-flight requests, as a cache key and to fetch resource, while the
+// original url is still reported back to the client.
+type Canonicalizer func(string) string
+
+// trackingParams are query parameters commonly added to urls by analytics and
+// advertising tools; they don't change resource urls point to. Names ending
+// with * match any parameter with such prefix.
+var trackingParams = []string{
+	"utm_*", "fbclid", "gclid", "gclsrc", "dclid", "msclkid", "yclid",
+	"twclid", "ttclid", "igshid", "mc_cid", "mc_eid", "mkt_tok", "_ga",
+	"_gl", "_hsenc", "_hsmi", "__hssc", "__hstc", "__hsfp", "hsCtaTracking",
+	"vero_id", "vero_conv", "oly_anon_id", "oly_enc_id", "wickedid",
+	"li_fat_id", "s_cid", "ref_src", "ref_url",
+}
+
+// NewCanonicalizer returns Canonicalizer that:
+//
+//   - lowercases scheme and host, converts internationalized domain names
+//     to their punycode form and drops default ports;
+//   - removes query parameters used for tracking (utm_*, fbclid, gclid,
+//     etc.) as well as extra parameters provided as arguments; names ending
+//     with * match any parameter with such prefix;
+//   - drops fragments unless they look like client-side routes (#!/path,
+//     #/path) or select lines of text files (#L10, #L10-L20).
+//
+// Urls that cannot be parsed are returned unchanged.
+func NewCanonicalizer(extraParams ...string) Canonicalizer {
+	names := make(map[string]struct{})
+	var prefixes []string
+	for _, p := range append(trackingParams, extraParams...) {
+		p = strings.ToLower(p)
+		if strings.HasSuffix(p, "*") {
+			prefixes = append(prefixes, strings.TrimSuffix(p, "*"))
+			continue
+		}
+		names[p] = struct{}{}
+	}
+	isTracking := func(name string) bool {
+		name = strings.ToLower(name)
+		if _, ok := names[name]; ok {
+			return true
+		}
+		for _, p := range prefixes {
+			if strings.HasPrefix(name, p) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(s string) string {
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" || u.Opaque != "" {
+			return s
+		}
+		host, port := u.Hostname(), u.Port()
+		if h, err := idna.Lookup.ToASCII(strings.TrimSuffix(host, ".")); err == nil {
+			host = h
+		} else {
+			host = strings.ToLower(host)
+		}
+		switch {
+		case port == "",
+			port == "80" && u.Scheme == "http",
+			port == "443" && u.Scheme == "https":
+			port = ""
+		}
+		if strings.IndexByte(host, ':') >= 0 { // ipv6 literal
+			host = "[" + host + "]"
+		}
+		u.Host = host
+		if port != "" {
+			u.Host += ":" + port
+		}
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		if u.RawQuery != "" {
+			params := strings.Split(u.RawQuery, "&")
+			kept := params[:0]
+			for _, p := range params {
+				if p == "" {
+					continue
+				}
+				name := p
+				if i := strings.IndexByte(p, '='); i >= 0 {
+					name = p[:i]
+				}
+				if n, err := url.QueryUnescape(name); err == nil {
+					name = n
+				}
+				if !isTracking(name) {
+					kept = append(kept, p)
+				}
+			}
+			u.RawQuery = strings.Join(kept, "&")
+		}
+		u.ForceQuery = false
+		if !keepFragment(u.Fragment) {
+			u.Fragment = ""
+		}
+		return u.String()
+	}
+}
+
+// keepFragment reports whether url fragment should be preserved by
+// canonicalization because it's likely used for client-side routing or
+// selects lines shown in text file preview.
+func keepFragment(f string) bool {
+	return strings.HasPrefix(f, "!") || strings.HasPrefix(f, "/") || lineRangeRe.MatchString(f)
+}
@@ -0,0 +1,22 @@
+package unfurlist
+
+import "fmt"
+
+func ExampleNewCanonicalizer() {
+	canonicalize := NewCanonicalizer("ref")
+	for _, u := range []string{
+		"https://Example.COM:443/page?id=1&utm_source=news&utm_medium=email&fbclid=abc#comments",
+		"http://пример.рф?ref=home",
+		"https://example.com/app#!/inbox",
+		"http://example.com:8080/?q=a+b&gclid=x&GCLID=y",
+		"https://raw.example.com/main.go?utm_campaign=x#L10-L20",
+	} {
+		fmt.Println(canonicalize(u))
+	}
+	// Output:
+	// https://example.com/page?id=1
+	// http://xn--e1afmkfd.xn--p1ai/
+	// https://example.com/app#!/inbox
+	// http://example.com:8080/?q=a+b
+	// https://raw.example.com/main.go#L10-L20
+}
@@ -35,10 +35,24 @@ func main() {
 		Timeout        time.Duration `flag:"timeout,timeout for remote i/o"`
 		GoogleMapsKey  string        `flag:"googlemapskey,Google Static Maps API key to generate map previews"`
 		VideoDomains   string        `flag:"videoDomains,comma-separated list of domains that host video+thumbnails"`
+		BareDomains    bool          `flag:"bareDomains,detect scheme-less urls like example.com/page by default"`
+		Canonicalize   bool          `flag:"canonicalize,strip tracking parameters and normalize urls before fetching and caching"`
+		Redirects      bool          `flag:"redirects,report redirect chain of each url"`
+		IconSize       int           `flag:"iconSize,preferred icon size in pixels"`
+		Manifests      bool          `flag:"manifests,fetch web app manifests for site names, theme colors and icons"`
+		Images         int           `flag:"images,number of image candidates to report in images list"`
+		ValidateImages bool          `flag:"validateImages,skip unreachable, non-image and too small preview images"`
+		Placeholders   bool          `flag:"placeholders,compute BlurHash and dominant color of preview images"`
+		ImageCacheTTL  time.Duration `flag:"imageCacheTTL,how long to cache image dimensions and validation results"`
+		ImageProxy     string        `flag:"imageProxy,public base url of image proxy served at /image/, e.g. https://unfurl.example.com/image"`
+		ImageProxyKey  string        `flag:"imageProxyKey,secret key to sign image proxy urls"`
+		ThumbnailDir   string        `flag:"thumbnailDir,directory to cache image proxy thumbnails in"`
+		FeedEntries    int           `flag:"feedEntries,number of latest entries to report for RSS and Atom feeds"`
 	}{
-		Listen:  "localhost:8080",
-		Pprof:   "localhost:6060",
-		Timeout: 30 * time.Second,
+		Listen:      "localhost:8080",
+		Pprof:       "localhost:6060",
+		Timeout:     30 * time.Second,
+		FeedEntries: 5,
 	}
 	autoflags.Define(&args)
 	flag.Parse()
@@ -69,6 +83,15 @@ func main() {
 		unfurlist.WithLogger(log.New(os.Stderr, "", log.LstdFlags)),
 		unfurlist.WithHTTPClient(httpClient),
 		unfurlist.WithImageDimensions(args.WithDimensions),
+		unfurlist.WithBareDomains(args.BareDomains),
+		unfurlist.WithRedirectChain(args.Redirects),
+		unfurlist.WithIconSize(args.IconSize),
+		unfurlist.WithManifests(args.Manifests),
+		unfurlist.WithImageCandidates(args.Images),
+		unfurlist.WithImageValidation(args.ValidateImages),
+		unfurlist.WithImagePlaceholders(args.Placeholders),
+		unfurlist.WithImageCacheTTL(args.ImageCacheTTL),
+		unfurlist.WithFeedEntries(args.FeedEntries),
 		unfurlist.WithBlacklistTitles(titleBlacklist),
 	}
 	if args.Blacklist != "" {
@@ -78,10 +101,19 @@ func main() {
 		}
 		configs = append(configs, unfurlist.WithBlacklistPrefixes(prefixes))
 	}
+	if args.Canonicalize {
+		configs = append(configs, unfurlist.WithCanonicalizer(unfurlist.NewCanonicalizer()))
+	}
 	if args.Cache != "" {
 		log.Print("Enable cache at ", args.Cache)
 		configs = append(configs, unfurlist.WithMemcache(memcache.New(args.Cache)))
 	}
+	if args.ImageProxy != "" && args.ImageProxyKey != "" {
+		configs = append(configs, unfurlist.WithImageProxy(args.ImageProxy, []byte(args.ImageProxyKey)))
+		if args.ThumbnailDir != "" {
+			configs = append(configs, unfurlist.WithThumbnailCache(args.ThumbnailDir))
+		}
+	}
 	var ff []unfurlist.FetchFunc
 	if args.GoogleMapsKey != "" {
 		ff = append(ff, unfurlist.GoogleMapsFetcher(args.GoogleMapsKey))
@@ -93,7 +125,12 @@ func main() {
 		configs = append(configs, unfurlist.WithFetchers(ff...))
 	}
 
-	handler := unfurlist.New(configs...)
+	mux := http.NewServeMux()
+	mux.Handle("/", unfurlist.New(configs...))
+	mux.Handle("/resolve", unfurlist.NewResolver(configs...))
+	if args.ImageProxy != "" && args.ImageProxyKey != "" {
+		mux.Handle("/image/", unfurlist.NewImageProxy([]byte(args.ImageProxyKey), configs...))
+	}
 	if args.Pprof != "" {
 		go func(addr string) { log.Println(http.ListenAndServe(addr, nil)) }(args.Pprof)
 	}
@@ -115,7 +152,7 @@ func main() {
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 60 * time.Second,
 		IdleTimeout:  30 * time.Second,
-		Handler:      handler,
+		Handler:      mux,
 	}
 	if args.Cert != "" && args.Key != "" {
 		log.Fatal(srv.ListenAndServeTLS(args.Cert, args.Key))
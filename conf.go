@@ -3,6 +3,7 @@ package unfurlist
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 )
@@ -80,6 +81,138 @@ func WithImageDimensions(enable bool) ConfFunc {
 	}
 }
 
+// WithBareDomains configures unfurl handler whether to detect scheme-less urls
+// like "www.example.com/page" or "example.com" in content by default.
+func WithBareDomains(enable bool) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		h.BareDomains = enable
+		return h
+	}
+}
+
+// WithCanonicalizer configures unfurl handler to rewrite urls to their
+// canonical form before deduplication, cache lookup and fetching, see
+// NewCanonicalizer. Urls reported back to the client are left intact.
+func WithCanonicalizer(c Canonicalizer) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		h.canonicalize = c
+		return h
+	}
+}
+
+// WithRedirectChain configures unfurl handler whether to report redirect
+// chain each url went through.
+func WithRedirectChain(enable bool) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		h.ReportRedirects = enable
+		return h
+	}
+}
+
+// WithManifests configures unfurl handler whether to fetch web app manifests
+// declared by pages to fill site name, theme color and icons.
+func WithManifests(enable bool) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		h.FetchManifest = enable
+		return h
+	}
+}
+
+// WithImageValidation configures unfurl handler whether to check that preview
+// images are reachable and are actual images of reasonable size. Broken images
+// are replaced with other candidates found on the page or dropped. Results of
+// such checks are cached if handler is configured with memcache.
+func WithImageValidation(enable bool) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		h.ValidateImages = enable
+		return h
+	}
+}
+
+// WithImagePlaceholders configures unfurl handler whether to compute BlurHash
+// and dominant color of preview images, so clients can show a placeholder of
+// the right aspect ratio before the image loads. This requires downloading the
+// whole image, results are cached along with image dimensions.
+func WithImagePlaceholders(enable bool) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		h.ImagePlaceholders = enable
+		return h
+	}
+}
+
+// WithImageCacheTTL configures how long results of image probes (dimensions,
+// validation) are cached if handler is configured with memcache. Images are
+// cached by their urls separately from pages, so pages sharing the same image
+// only cost a single probe.
+func WithImageCacheTTL(ttl time.Duration) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		if ttl > 0 {
+			h.ImageCacheTTL = ttl
+		}
+		return h
+	}
+}
+
+// WithImageCandidates configures unfurl handler to report up to n best image
+// candidates in result's images list. Zero disables such list.
+func WithImageCandidates(n int) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		if n >= 0 {
+			h.ImageCandidates = n
+		}
+		return h
+	}
+}
+
+// WithImageProxy configures unfurl handler to replace image and icon urls
+// with signed urls of image proxy (see NewImageProxy) served at base url and
+// using the same key. Urls are only rewritten in responses, cached results
+// keep original urls. Urls of svg images are not rewritten, as proxy doesn't
+// serve them.
+func WithImageProxy(base string, key []byte) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		if base != "" && len(key) > 0 {
+			h.imageProxy = &imageProxy{base: strings.TrimSuffix(base, "/"), key: key}
+		}
+		return h
+	}
+}
+
+// WithThumbnailCache configures image proxy created by NewImageProxy to keep
+// generated thumbnails in given directory. Directory is expected to exist.
+// Once thumbnails take more than 1GiB, the oldest ones are removed.
+func WithThumbnailCache(dir string) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		h.thumbs = nil
+		if dir != "" {
+			h.thumbs = newThumbCache(dir, maxThumbCacheSize)
+		}
+		return h
+	}
+}
+
+// WithIconSize configures unfurl handler to pick icon which size suits
+// provided size (in pixels) best among icons declared by page.
+func WithIconSize(size int) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		if size > 0 {
+			h.IconSize = size
+		}
+		return h
+	}
+}
+
+// WithFeedEntries configures unfurl handler to report up to n latest entries
+// of RSS and Atom feeds, default is 5. Zero disables reporting of entries.
+func WithFeedEntries(n int) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		if n >= 0 {
+			h.FeedEntries = n
+		}
+		return h
+	}
+}
+
 // WithFetchers attaches custom fetchers to unfurl handler created by New().
 func WithFetchers(fetchers ...FetchFunc) ConfFunc {
 	return func(h *unfurlHandler) *unfurlHandler {
@@ -88,6 +221,17 @@ func WithFetchers(fetchers ...FetchFunc) ConfFunc {
 	}
 }
 
+// WithCustomFetchers attaches custom fetchers implementing Fetcher interface
+// to unfurl handler created by New(). Unlike WithFetchers, it can be used
+// multiple times, each call adds fetchers to the ones attached before. They
+// are tried after fetchers attached with WithFetchers.
+func WithCustomFetchers(fetchers ...Fetcher) ConfFunc {
+	return func(h *unfurlHandler) *unfurlHandler {
+		h.customFetchers = append(h.customFetchers, fetchers...)
+		return h
+	}
+}
+
 // WithLogger configures unfurl handler to use provided logger
 func WithLogger(l Logger) ConfFunc {
 	return func(h *unfurlHandler) *unfurlHandler {
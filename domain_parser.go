@@ -0,0 +1,82 @@
+package unfurlist
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// reBareDomains matches scheme-less url candidates: dot-separated domain
+// labels ending with ascii-only top-level domain, followed by optional port and
+// path; see bareDomainSpans for additional checks.
+var reBareDomains = regexp.MustCompile(`(?:[\pL\pN](?:[\pL\pN-]{0,61}[\pL\pN])?\.)+[a-zA-Z]{2,63}(?::[0-9]{1,5})?(?:[/?#][%:/?#\[\]@!$&'\(\){}*+,;=\pL\pN._~-]*)?`)
+
+// fileExtensions are common file name extensions which are also valid
+// top-level domains. Bare names with such top-level domains are only
+// considered urls if they have "www." prefix or path.
+var fileExtensions = map[string]struct{}{
+	"ai": {}, "app": {}, "cc": {}, "cs": {}, "md": {}, "mk": {}, "mov": {},
+	"ms": {}, "pl": {}, "pm": {}, "ps": {}, "py": {}, "rs": {}, "sh": {},
+	"so": {}, "zip": {},
+}
+
+// textSpansBare works like textSpans, but also reports spans of scheme-less
+// urls like "www.example.com/page" or "example.com", see bareDomainSpans.
+func textSpansBare(content string) []textSpan {
+	spans := textSpans(content)
+	masked := []byte(content)
+	for _, s := range spans {
+		blank(masked[s.start:s.end])
+	}
+	if bare := bareDomainSpans(string(masked)); len(bare) > 0 {
+		spans = append(spans, bare...)
+		sort.Sort(spansByPos(spans))
+	}
+	return spans
+}
+
+// bareDomainSpans returns spans of scheme-less urls found in content. Domain
+// of each candidate must end with suffix from public suffix list and be
+// registrable under it, which filters out most of false positives like file
+// names or version numbers. Matched urls are normalized to have https scheme.
+func bareDomainSpans(content string) []textSpan {
+	var spans []textSpan
+	for _, m := range reBareDomains.FindAllStringIndex(content, -1) {
+		if r, _ := utf8.DecodeLastRuneInString(content[:m[0]]); m[0] > 0 &&
+			(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(`.@/:_-\`, r)) {
+			continue // part of something else: email, path, identifier
+		}
+		if m[1] < len(content) && strings.ContainsRune(`@_`, rune(content[m[1]])) {
+			continue
+		}
+		s := trimTrailingPunct(content[m[0]:m[1]])
+		host := s
+		if i := strings.IndexAny(host, ":/?#"); i >= 0 {
+			host = host[:i]
+		}
+		if !registrableDomain(host) {
+			continue
+		}
+		if _, ok := fileExtensions[strings.ToLower(host[strings.LastIndexByte(host, '.')+1:])]; ok &&
+			len(host) == len(s) && !strings.HasPrefix(strings.ToLower(host), "www.") {
+			continue
+		}
+		spans = append(spans, textSpan{url: "https://" + s, start: m[0], end: m[0] + len(s)})
+	}
+	return spans
+}
+
+// registrableDomain reports whether host is a domain under known public
+// suffix, but not a public suffix itself.
+func registrableDomain(host string) bool {
+	host = strings.ToLower(host)
+	ps, icann := publicsuffix.PublicSuffix(host)
+	if !icann && strings.IndexByte(ps, '.') < 0 {
+		return false // not on the list, default rule applied
+	}
+	return len(host) > len(ps)+1 && host[len(host)-len(ps)-1] == '.'
+}
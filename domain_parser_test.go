@@ -0,0 +1,49 @@
+package unfurlist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBareDomainSpans(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  []string
+	}{
+		{"visit www.example.com/page.", []string{"https://www.example.com/page"}},
+		{"see example.com, or (example.co.uk)", []string{"https://example.com", "https://example.co.uk"}},
+		{"edit file.txt and README.md, then run main.py", nil},
+		{"but www.example.py and example.py/docs are ok", []string{"https://www.example.py", "https://example.py/docs"}},
+		{"upgrade to v1.2.3 or 10.0.0.1", nil},
+		{"mail john@example.com", nil},
+		{"co.uk is a public suffix", nil},
+		{"call obj.method() on foo.qux", nil},
+		{"localhost:8080/path", nil},
+	}
+	for _, tc := range testCases {
+		var got []string
+		for _, s := range bareDomainSpans(tc.input) {
+			got = append(got, s.url)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestParseLinks_bare(t *testing.T) {
+	content := "http://example.com/a and example.org/b"
+	for _, tc := range []struct {
+		bare bool
+		want int
+	}{{false, 1}, {true, 2}} {
+		got, _ := parseLinks(content, formatText, tc.bare, -1)
+		if len(got) != tc.want {
+			t.Errorf("bare=%v: got %+v, want %d links", tc.bare, got, tc.want)
+		}
+	}
+	got, _ := parseLinks(content, formatText, true, -1)
+	if s := content[got[1].Offset : got[1].Offset+got[1].Length]; s != "example.org/b" {
+		t.Errorf("unexpected span %q", s)
+	}
+}
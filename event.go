@@ -0,0 +1,375 @@
+package unfurlist
+
+import (
+	"bufio"
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// eventInfo describes calendar event
+type eventInfo struct {
+	Title      string `json:"title,omitempty"`
+	Start      string `json:"start,omitempty"`    // RFC 3339, or date of all-day event
+	End        string `json:"end,omitempty"`      // RFC 3339, or date of all-day event
+	TimeZone   string `json:"timezone,omitempty"` // time zone name of start
+	AllDay     bool   `json:"all_day,omitempty"`
+	Location   string `json:"location,omitempty"`
+	Organizer  string `json:"organizer,omitempty"`
+	Recurrence string `json:"recurrence,omitempty"` // RRULE, like "FREQ=WEEKLY;BYDAY=MO"
+
+	description string
+}
+
+// isCalendar reports whether resource is an iCalendar (RFC 5545) file
+func isCalendar(chunk *pageChunk) bool {
+	if ct, _, _ := mime.ParseMediaType(chunk.ct); ct == "text/calendar" {
+		return true
+	}
+	data := bytes.TrimPrefix(chunk.data, []byte("\xef\xbb\xbf"))
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) > 15 && strings.EqualFold(string(data[:15]), "BEGIN:VCALENDAR") {
+		return true
+	}
+	return false
+}
+
+// icalLine is a content line of iCalendar file
+type icalLine struct {
+	name   string // upper case
+	params map[string]string
+	value  string
+}
+
+// icalLines splits iCalendar data into unfolded content lines
+func icalLines(data []byte) []icalLine {
+	var out []icalLine
+	var cur []byte
+	flush := func() {
+		if l, ok := parseICalLine(string(cur)); ok {
+			out = append(out, l)
+		}
+		cur = cur[:0]
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for sc.Scan() {
+		line := bytes.TrimSuffix(sc.Bytes(), []byte{'\r'})
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			cur = append(cur, line[1:]...)
+			continue
+		}
+		flush()
+		cur = append(cur, line...)
+	}
+	flush()
+	return out
+}
+
+// parseICalLine parses content line like
+// `ORGANIZER;CN="Doe, Jane":mailto:jane@example.com`
+func parseICalLine(s string) (icalLine, bool) {
+	var quoted bool
+	colon := -1
+	for i := 0; i < len(s) && colon < 0; i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				colon = i
+			}
+		}
+	}
+	if colon <= 0 {
+		return icalLine{}, false
+	}
+	fields := splitUnquoted(s[:colon], ';')
+	l := icalLine{name: strings.ToUpper(strings.TrimSpace(fields[0])), value: s[colon+1:]}
+	for _, f := range fields[1:] {
+		if i := strings.IndexByte(f, '='); i > 0 {
+			if l.params == nil {
+				l.params = make(map[string]string)
+			}
+			l.params[strings.ToUpper(f[:i])] = strings.Trim(f[i+1:], `"`)
+		}
+	}
+	return l, true
+}
+
+// splitUnquoted splits s by sep found outside of double quotes
+func splitUnquoted(s string, sep byte) []string {
+	var out []string
+	var quoted bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				out = append(out, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, s[start:])
+}
+
+// icalText unescapes TEXT value
+func icalText(s string) string {
+	return strings.TrimSpace(strings.NewReplacer(
+		`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`,
+	).Replace(s))
+}
+
+// parseICalendar returns the first event (VEVENT component) of iCalendar
+// data, or nil if there is none
+func parseICalendar(data []byte) *eventInfo {
+	var ev *eventInfo
+	var depth int // nesting level of components inside VEVENT, like VALARM
+	var start icalTime
+	var dur time.Duration
+	var hasEnd bool
+	for _, l := range icalLines(data) {
+		switch {
+		case l.name == "BEGIN" && ev == nil:
+			if strings.EqualFold(l.value, "VEVENT") {
+				ev = new(eventInfo)
+			}
+			continue
+		case ev == nil:
+			continue
+		case l.name == "BEGIN":
+			depth++
+			continue
+		case l.name == "END" && depth > 0:
+			depth--
+			continue
+		case l.name == "END":
+			if !hasEnd && !start.IsZero() && dur > 0 {
+				end := start
+				end.Time = start.Add(dur)
+				ev.End = end.String()
+			}
+			return ev
+		case depth > 0:
+			continue
+		}
+		switch l.name {
+		case "SUMMARY":
+			ev.Title = icalText(l.value)
+		case "DESCRIPTION":
+			ev.description = icalText(l.value)
+		case "LOCATION":
+			ev.Location = icalText(l.value)
+		case "ORGANIZER":
+			ev.Organizer = l.params["CN"]
+			if ev.Organizer == "" {
+				ev.Organizer = strings.TrimPrefix(strings.TrimPrefix(l.value, "mailto:"), "MAILTO:")
+			}
+		case "RRULE":
+			ev.Recurrence = strings.TrimSpace(l.value)
+		case "DTSTART":
+			if t, ok := parseICalTime(l); ok {
+				start = t
+				ev.Start, ev.AllDay = t.String(), t.allDay
+				ev.TimeZone = l.params["TZID"]
+				if ev.TimeZone == "" && !t.allDay && !t.floating {
+					ev.TimeZone = "UTC"
+				}
+			}
+		case "DTEND", "DUE":
+			if t, ok := parseICalTime(l); ok {
+				ev.End, hasEnd = t.String(), true
+			}
+		case "DURATION":
+			dur, _ = parseICalDuration(l.value)
+		}
+	}
+	return nil
+}
+
+// icalTime is DATE or DATE-TIME value of iCalendar property
+type icalTime struct {
+	time.Time
+	allDay   bool
+	floating bool // local time without zone, or in unknown zone
+}
+
+func (t icalTime) String() string {
+	switch {
+	case t.allDay:
+		return t.Format("2006-01-02")
+	case t.floating:
+		return t.Format("2006-01-02T15:04:05")
+	}
+	return t.Format(time.RFC3339)
+}
+
+// parseICalTime parses value of DTSTART or DTEND property; times with TZID
+// parameter are placed into that time zone if it is known
+func parseICalTime(l icalLine) (icalTime, bool) {
+	v := strings.TrimSpace(l.value)
+	if l.params["VALUE"] == "DATE" || len(v) == 8 {
+		t, err := time.Parse("20060102", v)
+		return icalTime{Time: t, allDay: true}, err == nil
+	}
+	if strings.HasSuffix(v, "Z") {
+		t, err := time.Parse("20060102T150405Z", v)
+		return icalTime{Time: t}, err == nil
+	}
+	loc, err := time.LoadLocation(strings.TrimPrefix(l.params["TZID"], "/"))
+	if l.params["TZID"] == "" || err != nil {
+		t, err := time.Parse("20060102T150405", v)
+		return icalTime{Time: t, floating: true}, err == nil
+	}
+	t, err := time.ParseInLocation("20060102T150405", v, loc)
+	return icalTime{Time: t}, err == nil
+}
+
+// parseICalDuration parses DURATION value like "PT1H30M" or "P1D"
+func parseICalDuration(s string) (time.Duration, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "+")
+	if !strings.HasPrefix(s, "P") || strings.HasPrefix(s, "-") {
+		return 0, false
+	}
+	var d time.Duration
+	var n int
+	var digits bool
+	for _, r := range s[1:] {
+		if r >= '0' && r <= '9' {
+			n, digits = n*10+int(r-'0'), true
+			continue
+		}
+		var unit time.Duration
+		switch r {
+		case 'T':
+			continue
+		case 'W':
+			unit = 7 * 24 * time.Hour
+		case 'D':
+			unit = 24 * time.Hour
+		case 'H':
+			unit = time.Hour
+		case 'M':
+			unit = time.Minute
+		case 'S':
+			unit = time.Second
+		default:
+			return 0, false
+		}
+		if !digits {
+			return 0, false
+		}
+		d += time.Duration(n) * unit
+		n, digits = 0, false
+	}
+	return d, d > 0
+}
+
+// applyEvent fills result with calendar event metadata
+func applyEvent(chunk *pageChunk, result *unfurlResult, ev *eventInfo) {
+	result.Type = "event"
+	if ev.Title != "" {
+		result.Title = ev.Title
+	} else if name := pathName(chunk.url); name != "" {
+		result.Title = name
+	}
+	if ev.description != "" {
+		result.Description = ev.description
+	}
+	result.Event = ev
+}
+
+// jsonLDEvent returns event described by JSON-LD Event object (or one of its
+// subtypes, like MusicEvent) of html page. Pages listing multiple events are
+// ignored.
+func jsonLDEvent(chunk *pageChunk) *eventInfo {
+	if !strings.HasPrefix(http.DetectContentType(chunk.data), "text/html") {
+		return nil
+	}
+	var ev *eventInfo
+	for _, obj := range jsonLDObjects(chunk.data, chunk.ct) {
+		if !jsonLDEventType(obj) {
+			continue
+		}
+		if ev != nil {
+			return nil
+		}
+		ev = &eventInfo{
+			Title:     jsonLDString(obj, "name"),
+			Location:  jsonLDString(obj, "location"),
+			Organizer: jsonLDString(obj, "organizer"),
+		}
+		if ev.Location == "" {
+			if loc, ok := obj["location"].(map[string]interface{}); ok {
+				ev.Location = jsonLDAddress(loc)
+			}
+		}
+		ev.Start, ev.AllDay = parseISODate(jsonLDString(obj, "startDate"))
+		ev.End, _ = parseISODate(jsonLDString(obj, "endDate"))
+	}
+	if ev == nil || ev.Title == "" && ev.Start == "" {
+		return nil
+	}
+	return ev
+}
+
+// jsonLDEventType reports whether JSON-LD object is of Event type or one of
+// its subtypes
+func jsonLDEventType(obj map[string]interface{}) bool {
+	var types []interface{}
+	switch t := obj["@type"].(type) {
+	case string:
+		types = []interface{}{t}
+	case []interface{}:
+		types = t
+	}
+	for _, x := range types {
+		if s, ok := x.(string); ok && strings.HasSuffix(s, "Event") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonLDAddress returns address of JSON-LD Place object, which is either
+// a string or PostalAddress object
+func jsonLDAddress(place map[string]interface{}) string {
+	if s, ok := place["address"].(string); ok {
+		return strings.TrimSpace(s)
+	}
+	addr, ok := place["address"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	var parts []string
+	for _, k := range []string{"streetAddress", "addressLocality", "addressRegion", "postalCode", "addressCountry"} {
+		if s := jsonLDString(addr, k); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseISODate normalizes ISO 8601 date or date-time value of JSON-LD: dates
+// are reported as all-day, date-times without offset are kept without it
+func parseISODate(s string) (string, bool) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Format("2006-01-02"), true
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format(time.RFC3339), false
+		}
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02T15:04:05"), false
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,93 @@
+package unfurlist
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseICalendar(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+		want eventInfo
+	}{
+		{"time zone", "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\n" +
+			"BEGIN:STANDARD\r\nDTSTART:19701025T030000\r\nEND:STANDARD\r\nEND:VTIMEZONE\r\n" +
+			"BEGIN:VEVENT\r\nSUMMARY:Team sync\\, weekly\r\n" +
+			"DTSTART;TZID=Europe/Berlin:20240115T100000\r\nDTEND;TZID=Europe/Berlin:20240115T103000\r\n" +
+			"LOCATION:Room 1\\nSecond floor\r\n" +
+			"ORGANIZER;CN=\"Doe: Jane\":mailto:jane@exam\r\n ple.com\r\n" +
+			"RRULE:FREQ=WEEKLY;BYDAY=MO\r\n" +
+			"BEGIN:VALARM\r\nTRIGGER:-PT15M\r\nDESCRIPTION:Reminder\r\nEND:VALARM\r\n" +
+			"END:VEVENT\r\nEND:VCALENDAR\r\n",
+			eventInfo{Title: "Team sync, weekly", Start: "2024-01-15T10:00:00+01:00",
+				End: "2024-01-15T10:30:00+01:00", TimeZone: "Europe/Berlin",
+				Location: "Room 1\nSecond floor", Organizer: "Doe: Jane",
+				Recurrence: "FREQ=WEEKLY;BYDAY=MO"}},
+		{"utc with duration", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Launch\n" +
+			"DTSTART:20240301T170000Z\nDURATION:PT1H30M\n" +
+			"ORGANIZER:mailto:team@example.com\nEND:VEVENT\nEND:VCALENDAR\n",
+			eventInfo{Title: "Launch", Start: "2024-03-01T17:00:00Z", End: "2024-03-01T18:30:00Z",
+				TimeZone: "UTC", Organizer: "team@example.com"}},
+		{"all day", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Holiday\n" +
+			"DTSTART;VALUE=DATE:20241225\nDTEND;VALUE=DATE:20241226\nEND:VEVENT\nEND:VCALENDAR\n",
+			eventInfo{Title: "Holiday", Start: "2024-12-25", End: "2024-12-26", AllDay: true}},
+		{"floating", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Lunch\n" +
+			"DTSTART;TZID=Custom Zone:20240102T120000\nEND:VEVENT\nEND:VCALENDAR\n",
+			eventInfo{Title: "Lunch", Start: "2024-01-02T12:00:00", TimeZone: "Custom Zone"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ev := parseICalendar([]byte(tc.data))
+			if ev == nil {
+				t.Fatal("no event found")
+			}
+			if *ev != tc.want {
+				t.Fatalf("got %+v, want %+v", *ev, tc.want)
+			}
+		})
+	}
+	if ev := parseICalendar([]byte("BEGIN:VCALENDAR\nBEGIN:VTODO\nEND:VTODO\nEND:VCALENDAR\n")); ev != nil {
+		t.Fatalf("unexpected event: %+v", *ev)
+	}
+}
+
+func TestUnfurlist__calendar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Write([]byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:Meetup\r\n" +
+			"DESCRIPTION:Talks and pizza\r\nDTSTART:20240510T180000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer srv.Close()
+
+	w := httptest.NewRecorder()
+	New().ServeHTTP(w, httptest.NewRequest("GET", "/?content="+url.QueryEscape(srv.URL+"/invite.ics"), nil))
+	var res []unfurlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+		t.Fatalf("unexpected response: %q", w.Body.String())
+	}
+	if r := res[0]; r.Type != "event" || r.Title != "Meetup" || r.Description != "Talks and pizza" ||
+		r.Event == nil || r.Event.Start != "2024-05-10T18:00:00Z" {
+		t.Fatalf("unexpected result: %q", w.Body.String())
+	}
+}
+
+func TestJSONLDEvent(t *testing.T) {
+	page := `<html><head><title>Concert</title>
+<script type="application/ld+json">{"@context": "https://schema.org", "@type": "MusicEvent",
+"name": "Spring Concert", "startDate": "2024-04-20T19:30:00-04:00", "endDate": "2024-04-20T22:00",
+"location": {"@type": "Place", "address": {"@type": "PostalAddress",
+"streetAddress": "1 Main St", "addressLocality": "Springfield"}},
+"organizer": {"@type": "Organization", "name": "City Orchestra"}}</script>
+</head><body></body></html>`
+	u, _ := url.Parse("https://example.com/concert")
+	ev := jsonLDEvent(&pageChunk{data: []byte(page), url: u, ct: "text/html"})
+	want := eventInfo{Title: "Spring Concert", Start: "2024-04-20T19:30:00-04:00",
+		End: "2024-04-20T22:00:00", Location: "1 Main St, Springfield", Organizer: "City Orchestra"}
+	if ev == nil || *ev != want {
+		t.Fatalf("got %+v, want %+v", ev, want)
+	}
+}
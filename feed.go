@@ -0,0 +1,210 @@
+package unfurlist
+
+import (
+	"bytes"
+	"encoding/xml"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// defaultFeedEntries is the default number of feed entries reported, see
+// WithFeedEntries
+const defaultFeedEntries = 5
+
+// feedEntry describes one of the latest entries of RSS or Atom feed
+type feedEntry struct {
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Date  string `json:"date,omitempty"` // RFC 3339
+}
+
+// feedMeta holds metadata of RSS or Atom feed
+type feedMeta struct {
+	title       string
+	description string
+	image       string
+	entries     []feedEntry
+}
+
+// isFeedType reports whether MIME type is one used for RSS or Atom feeds
+func isFeedType(ct string) bool {
+	switch strings.ToLower(strings.TrimSpace(ct)) {
+	case "application/rss+xml", "application/atom+xml":
+		return true
+	}
+	return false
+}
+
+// feedXMLEntry is RSS item or Atom entry
+type feedXMLEntry struct {
+	Title string `xml:"title"`
+	Links []struct {
+		Rel  string `xml:"rel,attr"`
+		Href string `xml:"href,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"link"`
+	GUID      string `xml:"guid"`
+	PubDate   string `xml:"pubDate"`
+	Date      string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+}
+
+func (e *feedXMLEntry) entry(base *url.URL) feedEntry {
+	var link string
+	for _, l := range e.Links {
+		if s := strings.TrimSpace(l.Text); s != "" { // rss
+			link = s
+			break
+		}
+		if l.Href != "" && (l.Rel == "" || l.Rel == "alternate") && link == "" { // atom
+			link = l.Href
+		}
+	}
+	if guid := strings.TrimSpace(e.GUID); link == "" && (strings.HasPrefix(guid, "http://") || strings.HasPrefix(guid, "https://")) {
+		link = guid
+	}
+	if u, err := base.Parse(link); err == nil && link != "" {
+		link = u.String()
+	}
+	entry := feedEntry{Title: strings.TrimSpace(e.Title), URL: link}
+	for _, s := range []string{e.Published, e.PubDate, e.Date, e.Updated} {
+		if t, ok := parseFeedDate(s); ok {
+			entry.Date = t.UTC().Format(time.RFC3339)
+			break
+		}
+	}
+	return entry
+}
+
+// parseFeed extracts metadata and up to n latest entries of RSS (0.9x, 1.0 or
+// 2.0) or Atom feed. Data may be truncated, in which case only entries which
+// are complete are reported. It returns nil if data is not a feed.
+func parseFeed(chunk *pageChunk, n int) *feedMeta {
+	switch ct, _, _ := mime.ParseMediaType(chunk.ct); ct {
+	case "application/rss+xml", "application/atom+xml", "application/rdf+xml",
+		"application/xml", "text/xml":
+	default:
+		return nil
+	}
+	d := xml.NewDecoder(bytes.NewReader(chunk.data))
+	d.CharsetReader = charset.NewReaderLabel
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	var meta *feedMeta
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return meta
+		}
+		t, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if meta == nil {
+			switch t.Name.Local {
+			case "rss", "RDF", "feed":
+				meta = new(feedMeta)
+				continue
+			}
+			return nil
+		}
+		switch t.Name.Local {
+		case "channel":
+			continue
+		case "item", "entry":
+			if len(meta.entries) >= n {
+				d.Skip()
+				continue
+			}
+			var e feedXMLEntry
+			if err := d.DecodeElement(&e, &t); err != nil {
+				return meta
+			}
+			meta.entries = append(meta.entries, e.entry(chunk.url))
+		case "title", "description", "subtitle", "logo", "icon":
+			var s string
+			if err := d.DecodeElement(&s, &t); err != nil {
+				return meta
+			}
+			s = strings.TrimSpace(s)
+			switch t.Name.Local {
+			case "title":
+				if meta.title == "" {
+					meta.title = s
+				}
+			case "description", "subtitle":
+				if meta.description == "" {
+					meta.description = s
+				}
+			default:
+				if meta.image == "" {
+					meta.image = s
+				}
+			}
+		case "image":
+			var img struct {
+				URL  string `xml:"url"`
+				Href string `xml:"href,attr"` // itunes:image
+			}
+			if err := d.DecodeElement(&img, &t); err != nil {
+				return meta
+			}
+			for _, s := range []string{img.URL, img.Href} {
+				if s = strings.TrimSpace(s); s != "" && meta.image == "" {
+					meta.image = s
+				}
+			}
+		default:
+			d.Skip()
+		}
+	}
+}
+
+// applyFeed fills result with feed metadata
+func applyFeed(chunk *pageChunk, result *unfurlResult, meta *feedMeta) {
+	result.Type = "feed"
+	if meta.title != "" {
+		result.Title = meta.title
+	}
+	if meta.description != "" {
+		result.Description = meta.description
+	}
+	if result.Image == "" && meta.image != "" {
+		if u, err := chunk.url.Parse(meta.image); err == nil {
+			result.Image = u.String()
+		}
+	}
+	result.Entries = meta.entries
+}
+
+// feedDateLayouts are layouts of dates found in feeds: RFC 822 dates of RSS
+// with common deviations, and RFC 3339 dates of Atom
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+func parseFeedDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
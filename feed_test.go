@@ -0,0 +1,83 @@
+package unfurlist
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseFeed(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/feed")
+	testCases := []struct {
+		name string
+		ct   string
+		data string
+		want *feedMeta
+	}{
+		{"rss", "application/rss+xml; charset=utf-8", `<?xml version="1.0"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel>
+<title>Example blog</title><link>https://example.com/blog</link>
+<atom:link href="https://example.com/blog/feed" rel="self" type="application/rss+xml"/>
+<description>News &amp; notes</description>
+<image><url>/logo.png</url><title>Example</title></image>
+<item><title>Second post</title><link>/blog/2</link><pubDate>Tue, 3 Jan 2023 10:00:00 +0100</pubDate></item>
+<item><title>First post</title><guid>https://example.com/blog/1</guid><pubDate>Mon, 02 Jan 2023 10:00:00 GMT</pubDate></item>
+<item><title>Older post</title><link>/blog/0</link></item>
+<item><title>Truncated post</title><link>/blog/-`,
+			&feedMeta{title: "Example blog", description: "News & notes", image: "/logo.png",
+				entries: []feedEntry{
+					{"Second post", "https://example.com/blog/2", "2023-01-03T09:00:00Z"},
+					{"First post", "https://example.com/blog/1", "2023-01-02T10:00:00Z"},
+				}}},
+		{"atom", "text/xml", `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Atom feed</title><subtitle>Updates</subtitle>
+<link href="https://example.com/" /><logo>https://example.com/logo.png</logo>
+<author><name>Jane Doe</name></author>
+<entry><title type="html">Hello</title><link rel="replies" href="/comments"/><link href="/hello"/>
+<published>2023-01-02T10:00:00Z</published><updated>2023-01-05T10:00:00Z</updated></entry>
+</feed>`,
+			&feedMeta{title: "Atom feed", description: "Updates", image: "https://example.com/logo.png",
+				entries: []feedEntry{{"Hello", "https://example.com/hello", "2023-01-02T10:00:00Z"}}}},
+		{"html", "text/html", `<html><head><title>Page</title></head></html>`, nil},
+		{"xml", "application/xml", `<?xml version="1.0"?><urlset><url>x</url></urlset>`, nil},
+	}
+	for _, tc := range testCases {
+		got := parseFeed(&pageChunk{data: []byte(tc.data), ct: tc.ct, url: base}, 2)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestUnfurlist__feed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Blog</title>
+		<link rel="alternate" type="application/rss+xml" href="/feed.xml"></head></html>`))
+	})
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<rss><channel><title>Blog feed</title>
+		<item><title>Post</title><link>/post</link></item></channel></rss>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	w := httptest.NewRecorder()
+	New().ServeHTTP(w, httptest.NewRequest("GET", "/?content="+srv.URL+"/page+"+srv.URL+"/feed.xml", nil))
+	var res []unfurlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 2 {
+		t.Fatalf("unexpected response: %q", w.Body.String())
+	}
+	if res[0].Type != "website" || res[0].FeedURL != srv.URL+"/feed.xml" {
+		t.Errorf("unexpected page result: %+v", res[0])
+	}
+	want := []feedEntry{{Title: "Post", URL: srv.URL + "/post"}}
+	if res[1].Type != "feed" || res[1].Title != "Blog feed" || !reflect.DeepEqual(res[1].Entries, want) {
+		t.Errorf("unexpected feed result: %+v", res[1])
+	}
+}
@@ -1,25 +1,300 @@
 package unfurlist
 
-import "net/url"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
 
 // FetchFunc defines custom metadata fetchers that can be attached to unfurl
 // handler
 type FetchFunc func(*url.URL) (*Metadata, bool)
 
-// Metadata represents metadata retrieved by FetchFunc. At least one of Title,
-// Description or Image attributes are expected to be non-empty.
+// Fetch implements Fetcher interface, so FetchFunc can be used where Fetcher
+// is expected; it returns ErrNoMatch if f does not recognize url.
+func (f FetchFunc) Fetch(_ context.Context, req *FetchRequest) (*Metadata, error) {
+	meta, ok := f(req.URL)
+	if !ok {
+		return nil, ErrNoMatch
+	}
+	return meta, nil
+}
+
+// Fetcher is a custom metadata fetcher that can be attached to unfurl handler
+// with WithCustomFetchers. Unlike FetchFunc, it is called with request
+// context, http client of the handler and the fetched page, so it may query
+// other services to build metadata, and it can report errors. Fetchers run in
+// Authoritative mode unless they implement ModeFetcher, see FetchMode.
+//
+// Fetcher should return ErrNoMatch if it does not recognize url; other errors
+// are logged. In both cases the next fetcher is tried.
+type Fetcher interface {
+	Fetch(ctx context.Context, req *FetchRequest) (*Metadata, error)
+}
+
+// FetcherFunc type is an adapter to allow the use of ordinary functions as
+// Fetcher.
+type FetcherFunc func(ctx context.Context, req *FetchRequest) (*Metadata, error)
+
+// Fetch calls f(ctx, req)
+func (f FetcherFunc) Fetch(ctx context.Context, req *FetchRequest) (*Metadata, error) {
+	return f(ctx, req)
+}
+
+// ErrNoMatch is returned by Fetcher if it does not recognize url
+var ErrNoMatch = errors.New("fetcher does not match url")
+
+// FetchRequest describes resource Fetcher is called for
+type FetchRequest struct {
+	URL *url.URL // url of resource, after all redirects unless fetcher is PreFetch
+
+	// Client is http client of unfurl handler; it follows handler redirect
+	// policy and adds extra headers configured with WithExtraHeaders to
+	// each request
+	Client *http.Client
+
+	// Page holds the first chunk of resource body, up to
+	// MaxBodyChunkSize bytes; it is empty for PreFetch fetchers and may
+	// be empty otherwise, i.e. for large binary files only requested
+	// with HEAD request
+	Page []byte
+
+	ContentType string // Content-Type of resource as reported by server
+
+	// Parsed holds metadata parsed from the page, only set for Enricher
+	// fetchers
+	Parsed *Metadata
+}
+
+// FetchMode defines when Fetcher is called and how its metadata is combined
+// with metadata parsed from the page
+type FetchMode int
+
+const (
+	// Authoritative fetchers are called after page is fetched, their
+	// metadata is used instead of OpenGraph and oEmbed metadata of the
+	// page; attributes left empty are still filled from basic html
+	// metadata. This is the default mode.
+	Authoritative FetchMode = iota
+
+	// PreFetch fetchers are called before page is fetched, with empty
+	// Page and ContentType of FetchRequest; if such fetcher matches, page
+	// is not fetched at all.
+	PreFetch
+
+	// Enricher fetchers are called after page is parsed, with parsed
+	// metadata in Parsed attribute of FetchRequest; non-empty attributes
+	// of their metadata override parsed ones, the rest is kept. Metadata
+	// of enrichers doesn't have to be Valid, all matching enrichers are
+	// applied in order.
+	Enricher
+)
+
+// ModeFetcher is implemented by fetchers running in mode other than
+// Authoritative
+type ModeFetcher interface {
+	Fetcher
+	FetchMode() FetchMode
+}
+
+// FetcherWithMode returns Fetcher calling f in given mode
+func FetcherWithMode(f Fetcher, mode FetchMode) ModeFetcher {
+	return modeFetcher{Fetcher: f, mode: mode}
+}
+
+type modeFetcher struct {
+	Fetcher
+	mode FetchMode
+}
+
+func (f modeFetcher) FetchMode() FetchMode { return f.mode }
+
+// fetchMode returns mode of fetcher
+func fetchMode(f Fetcher) FetchMode {
+	if mf, ok := f.(ModeFetcher); ok {
+		return mf.FetchMode()
+	}
+	return Authoritative
+}
+
+// Metadata represents metadata retrieved by FetchFunc or Fetcher. At least one
+// of Title, Description or Image attributes are expected to be non-empty.
 type Metadata struct {
 	Title       string
 	Type        string // TODO: make this int8 w/enum constants
 	Description string
+	SiteName    string
+	Author      string
 	Image       string // image/thumbnail url
 	ImageWidth  int
 	ImageHeight int
 	IconType    string // link rel=icon type (e.g. image/png)
 	IconUrl     string // link rel=icon href (URL)
+	IconWidth   int
+	IconHeight  int
+	ThemeColor  string // like "#3a6ea5"
 }
 
 // Valid check that at least one of the mandatory attributes is non-empty
 func (m *Metadata) Valid() bool {
-	return m != nil || m.Title != "" || m.Description != "" || m.Image != ""
+	return m != nil && (m.Title != "" || m.Description != "" || m.Image != "")
+}
+
+// replace sets attributes of result to the ones of m, see Authoritative
+func (m *Metadata) replace(result *unfurlResult) {
+	result.Title = m.Title
+	result.Type = m.Type
+	result.Description = m.Description
+	result.SiteName = m.SiteName
+	result.Author = m.Author
+	result.Image = m.Image
+	result.ImageWidth = m.ImageWidth
+	result.ImageHeight = m.ImageHeight
+	result.IconUrl = m.IconUrl
+	result.IconType = m.IconType
+	result.IconWidth = m.IconWidth
+	result.IconHeight = m.IconHeight
+	result.ThemeColor = m.ThemeColor
+}
+
+// merge overrides attributes of result with non-empty attributes of m, see
+// Enricher
+func (m *Metadata) merge(result *unfurlResult) {
+	if m.Title != "" {
+		result.Title = m.Title
+	}
+	if m.Type != "" {
+		result.Type = m.Type
+	}
+	if m.Description != "" {
+		result.Description = m.Description
+	}
+	if m.SiteName != "" {
+		result.SiteName = m.SiteName
+	}
+	if m.Author != "" {
+		result.Author = m.Author
+	}
+	if m.Image != "" {
+		result.Image = m.Image
+		result.ImageWidth, result.ImageHeight = m.ImageWidth, m.ImageHeight
+		result.ImageType, result.ImageSize = "", 0
+	}
+	if m.IconUrl != "" {
+		result.IconUrl, result.IconType = m.IconUrl, m.IconType
+		result.IconWidth, result.IconHeight = m.IconWidth, m.IconHeight
+	}
+	if m.ThemeColor != "" {
+		result.ThemeColor = m.ThemeColor
+	}
+}
+
+// metadata returns Metadata holding attributes of result
+func (u *unfurlResult) metadata() *Metadata {
+	return &Metadata{
+		Title:       u.Title,
+		Type:        u.Type,
+		Description: u.Description,
+		SiteName:    u.SiteName,
+		Author:      u.Author,
+		Image:       u.Image,
+		ImageWidth:  u.ImageWidth,
+		ImageHeight: u.ImageHeight,
+		IconType:    u.IconType,
+		IconUrl:     u.IconUrl,
+		IconWidth:   u.IconWidth,
+		IconHeight:  u.IconHeight,
+		ThemeColor:  u.ThemeColor,
+	}
+}
+
+// allFetchers returns fetchers attached with WithFetchers followed by ones
+// attached with WithCustomFetchers
+func (h *unfurlHandler) allFetchers() []Fetcher {
+	out := make([]Fetcher, 0, len(h.fetchers)+len(h.customFetchers))
+	for _, f := range h.fetchers {
+		out = append(out, f)
+	}
+	return append(out, h.customFetchers...)
+}
+
+// fetch calls fetchers of given mode in order and returns metadata of the
+// first matching one
+func (h *unfurlHandler) fetch(ctx context.Context, mode FetchMode, req *FetchRequest) (*Metadata, bool) {
+	for _, f := range h.allFetchers() {
+		if fetchMode(f) != mode {
+			continue
+		}
+		meta, err := f.Fetch(ctx, req)
+		if err != nil && err != ErrNoMatch {
+			h.Log.Printf("fetcher for %q: %v", req.URL, err)
+		}
+		if err == nil && meta.Valid() {
+			return meta, true
+		}
+	}
+	return nil, false
+}
+
+// enrich applies metadata of Enricher fetchers to result
+func (h *unfurlHandler) enrich(ctx context.Context, chunk *pageChunk, result *unfurlResult) (imageChanged bool) {
+	for _, f := range h.allFetchers() {
+		if fetchMode(f) != Enricher {
+			continue
+		}
+		meta, err := f.Fetch(ctx, &FetchRequest{
+			URL:         chunk.url,
+			Client:      h.fetchClient,
+			Page:        chunk.data,
+			ContentType: chunk.ct,
+			Parsed:      result.metadata(),
+		})
+		if err != nil && err != ErrNoMatch {
+			h.Log.Printf("fetcher for %q: %v", chunk.url, err)
+		}
+		if err != nil || meta == nil {
+			continue
+		}
+		meta.merge(result)
+		imageChanged = imageChanged || meta.Image != ""
+	}
+	return imageChanged
+}
+
+// fetcherClient returns copy of client which adds extra headers (key-value
+// pairs) to each request not having them already
+func fetcherClient(client *http.Client, headers []string) *http.Client {
+	if len(headers) == 0 {
+		return client
+	}
+	cl := *client
+	cl.Transport = &headerTransport{base: client.Transport, headers: headers}
+	return &cl
+}
+
+// headerTransport is http.RoundTripper adding extra headers to requests
+type headerTransport struct {
+	base    http.RoundTripper // http.DefaultTransport if nil
+	headers []string          // key-value pairs
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// RoundTripper must not modify request, so headers are set on copy
+	r2 := new(http.Request)
+	*r2 = *req
+	r2.Header = make(http.Header, len(req.Header)+len(t.headers)/2)
+	for k, v := range req.Header {
+		r2.Header[k] = v
+	}
+	for i := 0; i < len(t.headers); i += 2 {
+		if r2.Header.Get(t.headers[i]) == "" {
+			r2.Header.Set(t.headers[i], t.headers[i+1])
+		}
+	}
+	return base.RoundTrip(r2)
 }
@@ -0,0 +1,155 @@
+package unfurlist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCustomFetchers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"title": "From API, token ` + r.Header.Get("X-Token") + `"}`))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><head><title>Page</title></head></html>`))
+		}
+	}))
+	defer srv.Close()
+
+	var legacyCalls int
+	legacy := FetchFunc(func(u *url.URL) (*Metadata, bool) {
+		legacyCalls++
+		return nil, false
+	})
+	failing := FetcherFunc(func(ctx context.Context, req *FetchRequest) (*Metadata, error) {
+		return nil, errors.New("service unavailable")
+	})
+	api := FetcherFunc(func(ctx context.Context, req *FetchRequest) (*Metadata, error) {
+		if !strings.Contains(string(req.Page), "<title>Page</title>") {
+			return nil, ErrNoMatch
+		}
+		r, err := http.NewRequest("GET", srv.URL+"/api", nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := req.Client.Do(r.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		var meta Metadata
+		if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+			return nil, err
+		}
+		meta.Type = "website"
+		return &meta, nil
+	})
+	replaced := FetchFunc(func(u *url.URL) (*Metadata, bool) {
+		t.Error("fetcher replaced by WithFetchers was called")
+		return nil, false
+	})
+	h := New(
+		WithFetchers(replaced),
+		WithExtraHeaders(map[string]string{"X-Token": "secret"}),
+		WithFetchers(legacy),
+		WithCustomFetchers(failing),
+		WithCustomFetchers(api),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?content="+srv.URL+"/page", nil))
+	var res []unfurlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+		t.Fatalf("unexpected response: %q", w.Body.String())
+	}
+	if res[0].Title != "From API, token secret" || legacyCalls != 1 {
+		t.Fatalf("unexpected result: %q, legacy fetcher called %d times", w.Body.String(), legacyCalls)
+	}
+}
+
+func TestHeaderTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("User-Agent") + "|" + r.Header.Get("X-Extra")))
+	}))
+	defer srv.Close()
+	client := fetcherClient(http.DefaultClient, []string{"User-Agent", "unfurlist", "X-Extra", "1"})
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("User-Agent", "custom")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if string(b) != "custom|1" {
+		t.Fatalf("unexpected headers: %q", b)
+	}
+	if req.Header.Get("X-Extra") != "" {
+		t.Fatal("original request was modified")
+	}
+}
+
+func TestFetchModes(t *testing.T) {
+	var knownFetched bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/known" {
+			knownFetched = true
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Page title</title>
+<meta property="og:title" content="OG title">
+<meta property="og:description" content="OG description">
+<meta property="og:site_name" content="Example">
+</head></html>`))
+	}))
+	defer srv.Close()
+
+	enricher := FetcherWithMode(FetcherFunc(func(ctx context.Context, req *FetchRequest) (*Metadata, error) {
+		if req.Parsed == nil || req.Parsed.Title != "OG title" {
+			return nil, errors.New("parsed metadata is missing")
+		}
+		return &Metadata{Image: "https://cdn.example.com/better.png"}, nil
+	}), Enricher)
+	prefetch := FetcherWithMode(FetcherFunc(func(ctx context.Context, req *FetchRequest) (*Metadata, error) {
+		if req.URL.Path != "/known" {
+			return nil, ErrNoMatch
+		}
+		return &Metadata{Title: "Known", Type: "website", SiteName: "Catalog"}, nil
+	}), PreFetch)
+	h := New(WithCustomFetchers(enricher, prefetch))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?content="+srv.URL+"/page+"+srv.URL+"/known", nil))
+	var res []unfurlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 2 {
+		t.Fatalf("unexpected response: %q", w.Body.String())
+	}
+	if r := res[0]; r.Title != "OG title" || r.Description != "OG description" ||
+		r.SiteName != "Example" || r.Image != "https://cdn.example.com/better.png" {
+		t.Errorf("enricher result: %+v", r)
+	}
+	if r := res[1]; r.Title != "Known" || r.SiteName != "Catalog" || r.FinalURL != "" {
+		t.Errorf("pre-fetch result: %+v", r)
+	}
+	if knownFetched {
+		t.Error("page matched by pre-fetch fetcher was fetched")
+	}
+}
+
+func TestMetadataValid(t *testing.T) {
+	var m *Metadata
+	if m.Valid() || (&Metadata{Type: "website"}).Valid() {
+		t.Fatal("metadata without title, description and image is valid")
+	}
+	if !(&Metadata{Image: "https://example.com/a.png"}).Valid() {
+		t.Fatal("metadata with image is not valid")
+	}
+}
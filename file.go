@@ -0,0 +1,132 @@
+package unfurlist
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// downloadExtensions are extensions of url paths which usually lead to binary
+// downloads; such urls are requested with HEAD request first, see headChunk
+var downloadExtensions = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true,
+	".7z": true, ".rar": true, ".tar": true, ".jar": true, ".iso": true,
+	".exe": true, ".msi": true, ".dmg": true, ".pkg": true, ".deb": true,
+	".rpm": true, ".apk": true, ".ipa": true, ".bin": true,
+	".xls": true, ".xlsx": true, ".ods": true,
+	".doc": true, ".docx": true, ".odt": true,
+	".ppt": true, ".pptx": true, ".odp": true,
+}
+
+func isDownloadURL(URL string) bool {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return false
+	}
+	return downloadExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+// binaryContentType reports whether MIME type is of binary file which is not
+// a web page or other resource unfurlist extracts metadata from
+func binaryContentType(ct string) bool {
+	switch {
+	case !strings.HasPrefix(ct, "application/"),
+		strings.HasSuffix(ct, "+xml"), strings.HasSuffix(ct, "+json"):
+		return false
+	}
+	switch ct {
+	case "application/pdf", "application/ogg", "application/xml",
+		"application/json", "application/javascript":
+		return false
+	}
+	return true
+}
+
+// headChunk makes HEAD request to url and returns chunk without data if
+// response headers say resource is a binary file larger than the chunk which
+// would be read otherwise. If ok is false, url should be requested with GET.
+func (h *unfurlHandler) headChunk(ctx context.Context, client *http.Client, URL string) (chunk *pageChunk, reqs []*http.Request, ok bool) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := h.newRequest(ctx, http.MethodHead, URL)
+	if err != nil {
+		return nil, nil, false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, false
+	}
+	resp.Body.Close()
+	if !h.largeBinary(resp) {
+		return nil, nil, false
+	}
+	reqs = requestChain(resp)
+	return &pageChunk{
+		url:       resp.Request.URL,
+		ct:        resp.Header.Get("Content-Type"),
+		header:    resp.Header,
+		redirects: redirectURLs(reqs[:len(reqs)-1]),
+	}, reqs, true
+}
+
+// largeBinary reports whether response headers say resource is a binary file
+// larger than the chunk which would be read otherwise, so its body is not
+// worth reading
+func (h *unfurlHandler) largeBinary(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || resp.ContentLength <= h.MaxBodyChunkSize {
+		return false
+	}
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return binaryContentType(mt)
+}
+
+// fileType returns MIME type of resource if it is a binary file like an
+// archive, installer or spreadsheet
+func fileType(chunk *pageChunk) (string, bool) {
+	ct, _, _ := mime.ParseMediaType(chunk.ct)
+	if len(chunk.data) > 0 && (ct == "" || ct == "application/octet-stream") {
+		if sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(chunk.data)); sniffed != "application/octet-stream" {
+			ct = sniffed
+		}
+	}
+	return ct, binaryContentType(ct)
+}
+
+// applyFile fills result with metadata of binary file taken from response
+// headers: title is set to file name, either from Content-Disposition header
+// or from url path.
+func applyFile(chunk *pageChunk, result *unfurlResult, mimeType string) {
+	result.Type = "file"
+	result.MIMEType = mimeType
+	var name string
+	if _, params, err := mime.ParseMediaType(chunk.header.Get("Content-Disposition")); err == nil {
+		name = path.Base(strings.Replace(params["filename"], `\`, "/", -1))
+	}
+	if name == "" || name == "." || name == "/" {
+		name = pathName(chunk.url)
+	}
+	if result.Title == "" {
+		result.Title = name
+	}
+	if n, err := strconv.ParseInt(chunk.header.Get("Content-Length"), 10, 64); err == nil && n > 0 {
+		result.FileSize = n
+	}
+	if t, err := http.ParseTime(chunk.header.Get("Last-Modified")); err == nil {
+		result.Modified = t.UTC().Format(time.RFC3339)
+	}
+}
+
+// pathName returns the last element of url path, or empty string if path
+// has no such element.
+func pathName(u *url.URL) string {
+	if name := path.Base(u.Path); name != "." && name != "/" {
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package unfurlist
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestUnfurlist__file(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dist/setup.exe", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("large file requested with %s", r.Method)
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", "104857600")
+		w.Header().Set("Content-Disposition", `attachment; filename="App Setup 1.0.exe"`)
+		w.Header().Set("Last-Modified", "Tue, 03 Jan 2023 10:00:00 GMT")
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write([]byte("PK\x03\x04 not really a zip"))
+	})
+	mux.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		// body is shorter than announced, reading it fails
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Length", "104857600")
+		w.Write([]byte("PK\x03\x04"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	testCases := []struct {
+		path string
+		want unfurlResult
+	}{
+		{"/dist/setup.exe", unfurlResult{Title: "App Setup 1.0.exe", MIMEType: "application/octet-stream",
+			FileSize: 104857600, Modified: "2023-01-03T10:00:00Z"}},
+		{"/download?id=1", unfurlResult{Title: "download", MIMEType: "application/zip", FileSize: 21}},
+		{"/export", unfurlResult{Title: "export", MIMEType: "application/zip", FileSize: 104857600}},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		New().ServeHTTP(w, httptest.NewRequest("GET", "/?content="+srv.URL+tc.path, nil))
+		var res []unfurlResult
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+			t.Fatalf("unexpected response: %q", w.Body.String())
+		}
+		r := res[0]
+		if r.Type != "file" || r.Title != tc.want.Title || r.MIMEType != tc.want.MIMEType ||
+			r.FileSize != tc.want.FileSize || r.Modified != tc.want.Modified {
+			t.Errorf("%s: unexpected result: %q", tc.path, w.Body.String())
+		}
+	}
+}
+
+func TestPathName(t *testing.T) {
+	for in, want := range map[string]string{
+		"http://example.com":                 "",
+		"http://example.com/":                "",
+		"http://example.com/docs/":           "docs",
+		"http://example.com/docs/report.pdf": "report.pdf",
+	} {
+		u, err := url.Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pathName(u); got != want {
+			t.Errorf("pathName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
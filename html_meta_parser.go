@@ -19,32 +19,50 @@ func basicParseHTML(chunk *pageChunk) *unfurlResult {
 	result := new(unfurlResult)
 	result.Type = http.DetectContentType(chunk.data)
 	switch {
-	case strings.HasPrefix(result.Type, "image/"):
+	case strings.HasPrefix(result.Type, "image/"), isImageFtyp(chunk.data):
 		result.Type = "image"
 		result.Image = chunk.url.String()
 	case strings.HasPrefix(result.Type, "text/"):
 		result.Type = "website"
 		// pass Content-Type from response headers as it may have
 		// charset definition like "text/html; charset=windows-1251"
-		if title, desc, iconType, iconUrl, err := extractData(chunk.data, chunk.ct); err == nil {
-			result.Title = title
-			result.Description = desc
-			result.IconType = iconType
-			result.IconUrl = iconUrl
+		if meta, err := extractData(chunk.data, chunk.ct); err == nil {
+			result.Title = meta.title
+			result.Description = meta.description
+			result.icons = meta.icons
+			result.CanonicalURL = meta.canonical
+			result.ThemeColor = meta.themeColor
+			result.manifest = meta.manifest
+			if u, err := chunk.url.Parse(meta.feed); err == nil && meta.feed != "" {
+				result.FeedURL = u.String()
+			}
 		}
 	case strings.HasPrefix(result.Type, "video/"):
 		result.Type = "video"
+	case strings.HasPrefix(result.Type, "audio/"):
+		result.Type = "audio"
 	}
 	return result
 }
 
-func extractData(htmlBody []byte, ct string) (title, description, iconType, iconUrl string, err error) {
+// htmlMeta holds metadata extracted from html page by extractData
+type htmlMeta struct {
+	title       string
+	description string
+	icons       []iconCandidate
+	canonical   string // link rel=canonical href
+	manifest    string // link rel=manifest href
+	feed        string // link rel=alternate href of RSS or Atom feed
+	themeColor  string // meta name=theme-color content
+}
+
+func extractData(htmlBody []byte, ct string) (*htmlMeta, error) {
 	bodyReader, err := charset.NewReader(bytes.NewReader(htmlBody), ct)
 	if err != nil {
-		return "", "", "", "", err
+		return nil, err
 	}
+	meta := new(htmlMeta)
 	z := html.NewTokenizer(bodyReader)
-tokenize:
 	for {
 		tt := z.Next()
 		switch tt {
@@ -52,50 +70,46 @@ tokenize:
 			if z.Err() == io.EOF {
 				goto finish
 			}
-			return "", "", "", "", z.Err()
+			return nil, z.Err()
 		case html.StartTagToken, html.SelfClosingTagToken:
 			name, hasAttr := z.TagName()
 			switch atom.Lookup(name) {
 			case atom.Body:
 				goto finish // title/meta should preceed body tag
 			case atom.Title:
-				if title != "" {
+				if meta.title != "" {
 					continue
 				}
 				if tt := z.Next(); tt == html.TextToken {
-					title = string(z.Text())
-					if description != "" && iconUrl != "" {
-						goto finish
-                    }
+					meta.title = string(z.Text())
 				}
 			case atom.Meta:
-				if description != "" {
-					continue
-				}
-				var content []byte
-				var isDescription bool
+				var name, content string
 				for hasAttr {
 					var k, v []byte
 					k, v, hasAttr = z.TagAttr()
 					switch string(k) {
 					case "name":
-						if !bytes.Equal(v, []byte("description")) {
-							continue tokenize
-						}
-						isDescription = true
+						name = string(v)
 					case "content":
-						content = v
+						content = string(v)
 					}
 				}
-				if isDescription && len(content) > 0 {
-					description = string(content)
-					if title != "" && iconUrl != "" {
-						goto finish
+				if content == "" {
+					continue
+				}
+				switch name {
+				case "description":
+					if meta.description == "" {
+						meta.description = content
+					}
+				case "theme-color":
+					if meta.themeColor == "" {
+						meta.themeColor = strings.TrimSpace(content)
 					}
 				}
 			case atom.Link:
-				var linkRel, linkType, linkHref string
-
+				var linkRel, linkType, linkHref, linkSizes string
 				for hasAttr {
 					var k, v []byte
 					k, v, hasAttr = z.TagAttr()
@@ -106,24 +120,46 @@ tokenize:
 						linkType = string(v)
 					case "href":
 						linkHref = string(v)
+					case "sizes":
+						linkSizes = string(v)
 					}
 				}
-				isRelIcon := linkRel == "icon" || strings.HasPrefix(linkRel, "icon ") || strings.Contains(linkRel, " icon ") || strings.HasSuffix(linkRel, " icon")
-				if isRelIcon && linkHref != "" {
-					iconUrl = linkHref
-					iconType = linkType
+				if linkHref == "" {
+					continue
+				}
+				if rel := iconRel(linkRel); rel != "" {
+					icon := iconCandidate{url: linkHref, typ: linkType, rel: rel}
+					icon.sizes, icon.any = parseIconSizes(linkSizes)
+					meta.icons = append(meta.icons, icon)
+					continue
+				}
+				switch {
+				case hasRel(linkRel, "canonical") && meta.canonical == "":
+					meta.canonical = linkHref
+				case hasRel(linkRel, "manifest") && meta.manifest == "":
+					meta.manifest = linkHref
+				case hasRel(linkRel, "alternate") && isFeedType(linkType) && meta.feed == "":
+					meta.feed = linkHref
 				}
-				linkRel = ""
-				linkType = ""
-				linkHref = ""
 			}
 		}
 	}
 finish:
-	if title != "" || description != "" {
-		return title, description, iconType, iconUrl, nil
+	if meta.title != "" || meta.description != "" {
+		return meta, nil
+	}
+	return nil, errNoMetadataFound
+}
+
+// hasRel reports whether space-separated list of link types (rel attribute
+// value) contains given type.
+func hasRel(rel, typ string) bool {
+	for _, s := range strings.Fields(rel) {
+		if strings.EqualFold(s, typ) {
+			return true
+		}
 	}
-	return "", "", "", "", errNoMetadataFound
+	return false
 }
 
 var (
@@ -16,13 +16,13 @@ func TestExtractData_explicitCharset(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	title, _, err := extractData(data, "text/html; charset=windows-1251")
+	meta, err := extractData(data, "text/html; charset=windows-1251")
 	if err != nil {
 		t.Fatal(err)
 	}
 	want := `Кубань и Адыгея объединят усилия по созданию курорта "Лагонаки"`
-	if title != want {
-		t.Fatalf("unexpected title: got %q, want %q", title, want)
+	if meta.title != want {
+		t.Fatalf("unexpected title: got %q, want %q", meta.title, want)
 	}
 }
 
@@ -31,13 +31,13 @@ func TestExtractData_multibyte1(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	title, _, err := extractData(data, "text/html")
+	meta, err := extractData(data, "text/html")
 	if err != nil {
 		t.Fatal(err)
 	}
 	want := `심장정지 환자 못살리는 119 구급차 - 1등 인터넷뉴스 조선닷컴 - 의료ㆍ보건`
-	if title != want {
-		t.Fatalf("unexpected title: got %q, want %q", title, want)
+	if meta.title != want {
+		t.Fatalf("unexpected title: got %q, want %q", meta.title, want)
 	}
 }
 
@@ -46,25 +46,25 @@ func TestExtractData_multibyte2(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	title, _, err := extractData(data, "text/html")
+	meta, err := extractData(data, "text/html")
 	if err != nil {
 		t.Fatal(err)
 	}
 	want := `【楽天市場】テレビ台【ALTER／アルター】コーナータイプ【ＴＶ台】薄型ＴＶ３７型対応 ＡＶ収納【ＡＶボード】【コーナーボード】【幅１００】◆代引不可★一部組立【駅伝_中_四】：インテリア雑貨通販 H-collection`
-	if title != want {
-		t.Fatalf("unexpected title: got %q, want %q", title, want)
+	if meta.title != want {
+		t.Fatalf("unexpected title: got %q, want %q", meta.title, want)
 	}
 }
 
 func TestExtractData(t *testing.T) {
 	for i, c := range titleTestCases {
-		title, _, err := extractData([]byte(c.body), "text/html")
+		meta, err := extractData([]byte(c.body), "text/html")
 		if err != nil {
 			t.Errorf("case %d failed: %v", i, err)
 			continue
 		}
-		if title != c.want {
-			t.Errorf("case %d mismatch: %q != %q", i, title, c.want)
+		if meta.title != c.want {
+			t.Errorf("case %d mismatch: %q != %q", i, meta.title, c.want)
 		}
 	}
 }
@@ -75,29 +75,37 @@ func TestExtractData_full(t *testing.T) {
 	<meta name="description" content="hello page">
 	<meta name="description" content="ignored">
 	<title>Hello</title>
+	<link rel="shortcut icon" type="image/png" href="/favicon.png">
+	<link rel="Canonical" href="https://example.com/hello">
 	</html>
 	`
-	title, desc, err := extractData([]byte(body), "text/html")
+	meta, err := extractData([]byte(body), "text/html")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if want := "Hello"; title != want {
-		t.Errorf("got title %q, want %q", title, want)
+	if want := "Hello"; meta.title != want {
+		t.Errorf("got title %q, want %q", meta.title, want)
 	}
-	if want := "hello page"; desc != want {
-		t.Errorf("got description %q, want %q", desc, want)
+	if want := "hello page"; meta.description != want {
+		t.Errorf("got description %q, want %q", meta.description, want)
+	}
+	if len(meta.icons) != 1 || meta.icons[0].url != "/favicon.png" || meta.icons[0].typ != "image/png" {
+		t.Errorf("got icons %+v, want single /favicon.png", meta.icons)
+	}
+	if want := "https://example.com/hello"; meta.canonical != want {
+		t.Errorf("got canonical url %q, want %q", meta.canonical, want)
 	}
 }
 
 func BenchmarkExtractData(b *testing.B) {
 	for j := 0; j < b.N; j++ {
 		for i, c := range titleTestCases {
-			title, _, err := extractData([]byte(c.body), "text/html")
+			meta, err := extractData([]byte(c.body), "text/html")
 			if err != nil {
 				b.Fatalf("case %d failed: %v", i, err)
 			}
-			if title != c.want {
-				b.Fatalf("case %d mismatch: %q != %q", i, title, c.want)
+			if meta.title != c.want {
+				b.Fatalf("case %d mismatch: %q != %q", i, meta.title, c.want)
 			}
 		}
 	}
@@ -0,0 +1,164 @@
+package unfurlist
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultIconSize is the default preferred icon size in pixels
+const defaultIconSize = 32
+
+// iconCandidate describes icon declared by page with <link rel="icon"> or
+// similar tags
+type iconCandidate struct {
+	url   string
+	typ   string     // mime type, if declared
+	rel   string     // icon, apple-touch-icon, mask-icon
+	sizes []iconSize // declared sizes, if any
+	any   bool       // sizes="any", scalable image
+}
+
+type iconSize struct{ width, height int }
+
+// iconRel returns kind of icon given link rel attribute value, or empty string
+// if it doesn't describe an icon.
+func iconRel(rel string) string {
+	switch {
+	case hasRel(rel, "apple-touch-icon"), hasRel(rel, "apple-touch-icon-precomposed"):
+		return "apple-touch-icon"
+	case hasRel(rel, "mask-icon"):
+		return "mask-icon"
+	case hasRel(rel, "icon"):
+		return "icon"
+	}
+	return ""
+}
+
+// parseIconSizes parses sizes attribute value like "16x16 32x32" or "any".
+func parseIconSizes(s string) (sizes []iconSize, any bool) {
+	for _, f := range strings.Fields(strings.ToLower(s)) {
+		if f == "any" {
+			any = true
+			continue
+		}
+		i := strings.IndexByte(f, 'x')
+		if i < 0 {
+			continue
+		}
+		w, err1 := strconv.Atoi(f[:i])
+		h, err2 := strconv.Atoi(f[i+1:])
+		if err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+			continue
+		}
+		sizes = append(sizes, iconSize{w, h})
+	}
+	return sizes, any
+}
+
+// bestIcon picks the icon which suits target size (in pixels) best: the
+// smallest icon not smaller than target, or the largest one if all are
+// smaller. Scalable icons come after those fitting target size, and
+// monochrome mask icons are only used as the last resort. Icon size is
+// returned if known.
+func bestIcon(icons []iconCandidate, target int) (icon iconCandidate, size iconSize, ok bool) {
+	// rank is the primary sort key, the lower the better; cost is the
+	// secondary one
+	bestRank, bestCost := 0, 0
+	consider := func(c iconCandidate, s iconSize, rank, cost int) {
+		if !ok || rank < bestRank || (rank == bestRank && cost < bestCost) {
+			icon, size, ok = c, s, true
+			bestRank, bestCost = rank, cost
+		}
+	}
+	for _, c := range icons {
+		if c.url == "" {
+			continue
+		}
+		if c.rel == "mask-icon" {
+			consider(c, iconSize{}, 4, 0)
+			continue
+		}
+		if c.any || strings.HasPrefix(c.typ, "image/svg") {
+			consider(c, iconSize{}, 1, 0)
+		}
+		sizes := c.sizes
+		if len(sizes) == 0 && !c.any {
+			// guess typical sizes of icons declared without sizes,
+			// but don't report them
+			guess := 16
+			if c.rel == "apple-touch-icon" {
+				guess = 180
+			}
+			if guess >= target {
+				consider(c, iconSize{}, 0, guess-target+1)
+			} else {
+				consider(c, iconSize{}, 3, target-guess)
+			}
+			continue
+		}
+		for _, s := range sizes {
+			dim := s.width
+			if s.height < dim {
+				dim = s.height
+			}
+			if dim >= target {
+				consider(c, s, 0, dim-target)
+			} else {
+				consider(c, s, 2, target-dim)
+			}
+		}
+	}
+	return icon, size, ok
+}
+
+// faviconHeadSize is the number of leading bytes of /favicon.ico read to check
+// it's not an html page
+const faviconHeadSize = 512
+
+// faviconFallback returns url of /favicon.ico on the same host as pageURL if
+// such resource exists. Only the first bytes of icon are requested.
+func (h *unfurlHandler) faviconFallback(ctx context.Context, pageURL *url.URL) (string, bool) {
+	if pageURL == nil || pageURL.Host == "" {
+		return "", false
+	}
+	u := &url.URL{Scheme: pageURL.Scheme, Host: pageURL.Host, Path: "/favicon.ico"}
+	if h.pmap != nil && h.pmap.Match(u.String()) {
+		return "", false
+	}
+	client := h.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := h.newRequest(ctx, http.MethodGet, u.String())
+	if err != nil {
+		return "", false
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", faviconHeadSize-1))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return "", false
+	}
+	iconURL := resp.Request.URL.String()
+	if h.pmap != nil && h.pmap.Match(iconURL) {
+		return "", false
+	}
+	// servers often respond with html page instead of 404
+	if strings.HasPrefix(strings.ToLower(resp.Header.Get("Content-Type")), "text/") {
+		return "", false
+	}
+	head, err := ioutil.ReadAll(io.LimitReader(resp.Body, faviconHeadSize))
+	if err != nil || len(head) == 0 || strings.HasPrefix(http.DetectContentType(head), "text/") {
+		return "", false
+	}
+	return iconURL, true
+}
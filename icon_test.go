@@ -0,0 +1,112 @@
+package unfurlist
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBestIcon(t *testing.T) {
+	icons := []iconCandidate{
+		{url: "/mask.svg", rel: "mask-icon"},
+		{url: "/favicon.ico", rel: "icon"},
+		{url: "/icon-16.png", rel: "icon", sizes: []iconSize{{16, 16}}},
+		{url: "/icon-multi.ico", rel: "icon", sizes: []iconSize{{32, 32}, {48, 48}}},
+		{url: "/icon.svg", rel: "icon", any: true},
+		{url: "/touch.png", rel: "apple-touch-icon"},
+		{url: "/icon-192.png", rel: "icon", sizes: []iconSize{{192, 192}}},
+	}
+	testCases := []struct {
+		target int
+		url    string
+		size   iconSize
+	}{
+		{16, "/icon-16.png", iconSize{16, 16}},
+		{24, "/icon-multi.ico", iconSize{32, 32}},
+		{40, "/icon-multi.ico", iconSize{48, 48}},
+		{64, "/touch.png", iconSize{}},
+		{192, "/icon-192.png", iconSize{192, 192}},
+		{512, "/icon.svg", iconSize{}},
+	}
+	for _, tc := range testCases {
+		icon, size, ok := bestIcon(icons, tc.target)
+		if !ok || icon.url != tc.url || size != tc.size {
+			t.Errorf("target %d: got %q %v, want %q %v", tc.target, icon.url, size, tc.url, tc.size)
+		}
+	}
+	if icon, _, _ := bestIcon(icons[:1], 32); icon.url != "/mask.svg" {
+		t.Errorf("mask icon should be used as the last resort, got %q", icon.url)
+	}
+	if _, _, ok := bestIcon(nil, 32); ok {
+		t.Error("icon found in empty list")
+	}
+}
+
+func TestParseIconSizes(t *testing.T) {
+	sizes, any := parseIconSizes("16x16 32X32 bogus 0x10 any")
+	if !any || len(sizes) != 2 || sizes[0] != (iconSize{16, 16}) || sizes[1] != (iconSize{32, 32}) {
+		t.Fatalf("got %v, %v", sizes, any)
+	}
+}
+
+func TestFaviconFallback(t *testing.T) {
+	var ranges []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if strings.HasPrefix(r.Host, "localhost:") {
+			w.Write([]byte("<!doctype html><html><title>Not found</title></html>"))
+			return
+		}
+		w.Write([]byte("\x00\x00\x01\x00\x01\x00\x10\x10"))
+	})
+	mux.HandleFunc("/notes.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("remember the milk\n"))
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<!doctype html><html><title>Page</title><p>Hello</p></html>"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	unfurl := func(h http.Handler, path string) unfurlResult {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/?content="+url.QueryEscape(srv.URL+path), nil))
+		var res []unfurlResult
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+			t.Fatalf("unexpected response: %q", w.Body.String())
+		}
+		return res[0]
+	}
+	if res := unfurl(New(), "/page.html"); res.IconUrl != srv.URL+"/favicon.ico" {
+		t.Fatalf("got icon %q, want %q", res.IconUrl, srv.URL+"/favicon.ico")
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=0-511" {
+		t.Fatalf("unexpected favicon requests: %q", ranges)
+	}
+	if res := unfurl(New(), "/notes.txt"); res.IconUrl != "" || len(ranges) != 1 {
+		t.Fatalf("favicon was requested for text file: %q", res.IconUrl)
+	}
+	fetcher := func(u *url.URL) (*Metadata, bool) {
+		return &Metadata{Title: "Fetched"}, u.Path == "/page.html"
+	}
+	if res := unfurl(New(WithFetchers(fetcher)), "/page.html"); res.IconUrl != "" || len(ranges) != 1 {
+		t.Fatalf("favicon was requested for page matched by fetcher: %q", res.IconUrl)
+	}
+
+	u, _ := url.Parse(srv.URL)
+	h := newHandler(WithBlacklistPrefixes([]string{srv.URL + "/favicon"}))
+	if icon, ok := h.faviconFallback(context.Background(), u); ok || len(ranges) != 1 {
+		t.Fatalf("blacklisted favicon was requested: %q", icon)
+	}
+	u.Host = "localhost:" + u.Port()
+	if icon, ok := newHandler().faviconFallback(context.Background(), u); ok {
+		t.Fatalf("html page was reported as favicon: %q", icon)
+	}
+}
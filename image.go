@@ -4,12 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"image"
-	_ "image/gif" // register supported image types
-	_ "image/jpeg"
-	_ "image/png"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -40,42 +38,105 @@ func absoluteImageURL(originURL, imageURL string) (string, error) {
 	return base.ResolveReference(iu).String(), nil
 }
 
-// imageDimensions tries to retrieve enough of image to get its dimensions. If
-// provided client is nil, http.DefaultClient is used.
-func imageDimensions(ctx context.Context, client *http.Client, imageURL string) (width, height int, err error) {
+// maxImageHeaderSize is the max number of bytes read from the beginning of
+// image to get its dimensions
+const maxImageHeaderSize = 128 * 1024
+
+// imageMeta describes image properties detected by imageDimensions
+type imageMeta struct {
+	width  int
+	height int
+	mime   string // detected mime type, i.e. image/webp
+	size   int64  // size of the whole image in bytes, if known
+
+	blurHash string // set by imagePlaceholder only
+	color    string // set by imagePlaceholder only
+}
+
+// brokenImageError is returned by imageDimensions if image is definitely
+// broken: server responded with client error, or resource is not an image of
+// known format. Unlike other errors, which may be transient, such failures are
+// cached.
+type brokenImageError string
+
+func (e brokenImageError) Error() string { return string(e) }
+
+// imageDimensions tries to retrieve enough of image to get its dimensions,
+// requesting only leading bytes of image with Range header. If provided client
+// is nil, http.DefaultClient is used.
+func imageDimensions(ctx context.Context, client *http.Client, imageURL string) (*imageMeta, error) {
 	cl := client
 	if cl == nil {
 		cl = http.DefaultClient
 	}
 	req, err := http.NewRequest(http.MethodGet, imageURL, nil)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", maxImageHeaderSize-1))
 	req = req.WithContext(ctx)
 	resp, err := cl.Do(req)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= http.StatusBadRequest {
-		return 0, 0, errors.New(resp.Status)
+	switch code := resp.StatusCode; {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests,
+		code >= http.StatusInternalServerError:
+		return nil, errors.New(resp.Status)
+	case code >= http.StatusBadRequest:
+		return nil, brokenImageError(resp.Status)
+	}
+	ct := strings.ToLower(resp.Header.Get("Content-Type"))
+	if !imageContentType(ct) {
+		return nil, brokenImageError(fmt.Sprintf("unsupported content-type %q", ct))
 	}
-	switch ct := strings.ToLower(resp.Header.Get("Content-Type")); ct {
-	case "image/jpeg", "image/png", "image/gif":
+	buf := make([]byte, maxImageHeaderSize)
+	n, err := io.ReadFull(resp.Body, buf)
+	switch err {
+	case nil, io.ErrUnexpectedEOF:
 	default:
-		// for broken servers responding with image/png;charset=UTF-8
-		// (i.e. www.evernote.com)
-		if strings.HasPrefix(ct, "image/jpeg") ||
-			strings.HasPrefix(ct, "image/png") ||
-			strings.HasPrefix(ct, "image/gif") {
-			break
-		}
-		return 0, 0, fmt.Errorf("unsupported content-type %q", ct)
-	}
-	cfg, _, err := image.DecodeConfig(resp.Body)
+		return nil, err
+	}
+	meta, err := decodeImageHeader(buf[:n], ct)
+	if err != nil {
+		return nil, brokenImageError(err.Error())
+	}
+	if size := responseSize(resp); size > 0 {
+		meta.size = size
+	}
+	return meta, nil
+}
+
+// imageContentType reports whether content type may describe an image:
+// image/* types and generic binary types some servers use for any static file
+func imageContentType(ct string) bool {
+	switch {
+	case ct == "",
+		strings.HasPrefix(ct, "image/"),
+		strings.HasPrefix(ct, "application/octet-stream"),
+		strings.HasPrefix(ct, "binary/octet-stream"):
+		return true
+	}
+	return false
+}
+
+// responseSize returns size of the whole resource, taking into account that
+// response may be a partial one. It returns -1 if size is unknown.
+func responseSize(resp *http.Response) int64 {
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.ContentLength
+	}
+	// Content-Range: bytes 0-1023/146515
+	cr := resp.Header.Get("Content-Range")
+	i := strings.LastIndexByte(cr, '/')
+	if i < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(cr[i+1:], 10, 64)
 	if err != nil {
-		return 0, 0, err
+		return -1
 	}
-	return cfg.Width, cfg.Height, nil
+	return size
 }
@@ -0,0 +1,381 @@
+package unfurlist
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// Image sources, in the order of preference
+const (
+	imageSourceOG       = "og"
+	imageSourceGiven    = "given" // image found by other parsers (i.e. oEmbed)
+	imageSourceTwitter  = "twitter"
+	imageSourceJSONLD   = "jsonld"
+	imageSourceImageSrc = "image_src"
+	imageSourceImg      = "img"
+)
+
+const (
+	minImageSide   = 100 // images with smaller width or height are rejected
+	minImgTagSide  = 200 // <img> tags with smaller declared dimensions are ignored
+	maxImageProbes = 3   // max number of candidates to probe for dimensions or validate
+	maxImageAspect = 3.0 // images with larger aspect ratio are penalized
+	imageBlocked   = -1
+)
+
+// imageCandidate describes image that can be used as a preview
+type imageCandidate struct {
+	url    string
+	width  int
+	height int
+	source string
+	score  int
+	mime   string // detected mime type, set if image was probed
+	size   int64  // image size in bytes, set if image was probed
+}
+
+// imageInfo is an image reported in the result's images list
+type imageInfo struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// imageSourceScore is the base score of image depending on where it was found
+var imageSourceScore = map[string]int{
+	imageSourceOG:       60,
+	imageSourceGiven:    55,
+	imageSourceTwitter:  50,
+	imageSourceJSONLD:   45,
+	imageSourceImageSrc: 40,
+	imageSourceImg:      20,
+}
+
+// blockedImageHosts are domains serving tracking pixels; subdomains are
+// blocked as well
+var blockedImageHosts = []string{
+	"doubleclick.net", "google-analytics.com", "googletagmanager.com",
+}
+
+// blockedImageNames are url path segments (with extension stripped) which are
+// likely tracking pixels or placeholders
+var blockedImageNames = map[string]bool{
+	"pixel": true, "beacon": true, "track": true, "tracking": true,
+	"spacer": true, "blank": true, "transparent": true, "1x1": true,
+}
+
+// penalizedImageWords are words of url path which are likely to name logos,
+// sprites or other non-representative images
+var penalizedImageWords = map[string]bool{
+	"logo": true, "logos": true, "sprite": true, "sprites": true,
+	"icon": true, "icons": true, "avatar": true, "avatars": true,
+	"placeholder": true,
+}
+
+// blockedImage reports whether image url likely points to tracking pixel or
+// placeholder: it's served by known tracker, or one of its path segments names
+// such image, like "/track/open.gif" or "/img/spacer.gif"
+func blockedImage(u *url.URL) bool {
+	host := strings.TrimSuffix(u.Hostname(), ".")
+	for _, h := range blockedImageHosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	if (host == "facebook.com" || strings.HasSuffix(host, ".facebook.com")) && u.Path == "/tr" {
+		return true
+	}
+	for _, seg := range strings.Split(u.Path, "/") {
+		if blockedImageNames[strings.TrimSuffix(seg, path.Ext(seg))] {
+			return true
+		}
+	}
+	return false
+}
+
+// penalizedImage reports whether url path has words naming non-representative
+// images, like "/img/site-logo.png" or "/icons/share.png", or file name is
+// "default" (but not directory, like Drupal "/sites/default/files/")
+func penalizedImage(u *url.URL) bool {
+	name := path.Base(u.Path)
+	if strings.TrimSuffix(name, path.Ext(name)) == "default" {
+		return true
+	}
+	words := strings.FieldsFunc(u.Path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if penalizedImageWords[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// scoreImage returns score of image candidate; the higher score, the better
+// image suits to be used as a preview. Negative score means image must not be
+// used at all.
+func scoreImage(c imageCandidate) int {
+	score := imageSourceScore[c.source]
+	if u, err := url.Parse(strings.ToLower(c.url)); err == nil {
+		if blockedImage(u) {
+			return imageBlocked
+		}
+		if penalizedImage(u) {
+			score -= 25
+		}
+	}
+	if c.width > 0 && c.height > 0 {
+		if c.width < minImageSide || c.height < minImageSide {
+			return imageBlocked
+		}
+		w, h := float64(c.width), float64(c.height)
+		if w/h > maxImageAspect || h/w > maxImageAspect {
+			score -= 30
+		}
+		bonus := c.width * c.height / 20000
+		if bonus > 30 {
+			bonus = 30
+		}
+		score += bonus
+	}
+	if score < 0 {
+		score = 0
+	}
+	return score
+}
+
+// htmlImageCandidates returns images found in html page: og:image*,
+// twitter:image, <link rel="image_src">, JSON-LD images and <img> tags with
+// large enough declared dimensions. Urls are returned as is.
+func htmlImageCandidates(htmlBody []byte, ct string) []imageCandidate {
+	bodyReader, err := charset.NewReader(bytes.NewReader(htmlBody), ct)
+	if err != nil {
+		return nil
+	}
+	var out []imageCandidate
+	lastOG := -1 // index of the last og:image, to attach dimensions to
+	z := html.NewTokenizer(bodyReader)
+tokenize:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break tokenize
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := make(map[string]string)
+			for hasAttr {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				attrs[string(k)] = strings.TrimSpace(string(v))
+			}
+			switch atom.Lookup(name) {
+			case atom.Meta:
+				prop := attrs["property"]
+				if prop == "" {
+					prop = attrs["name"]
+				}
+				content := attrs["content"]
+				if content == "" {
+					continue
+				}
+				switch strings.ToLower(prop) {
+				case "og:image", "og:image:url", "og:image:secure_url":
+					if lastOG >= 0 && out[lastOG].url == content {
+						continue
+					}
+					out = append(out, imageCandidate{url: content, source: imageSourceOG})
+					lastOG = len(out) - 1
+				case "og:image:width":
+					if lastOG >= 0 {
+						out[lastOG].width, _ = strconv.Atoi(content)
+					}
+				case "og:image:height":
+					if lastOG >= 0 {
+						out[lastOG].height, _ = strconv.Atoi(content)
+					}
+				case "twitter:image", "twitter:image:src":
+					out = append(out, imageCandidate{url: content, source: imageSourceTwitter})
+				}
+			case atom.Link:
+				if hasRel(attrs["rel"], "image_src") && attrs["href"] != "" {
+					out = append(out, imageCandidate{url: attrs["href"], source: imageSourceImageSrc})
+				}
+			case atom.Img:
+				w, _ := strconv.Atoi(strings.TrimSuffix(attrs["width"], "px"))
+				h, _ := strconv.Atoi(strings.TrimSuffix(attrs["height"], "px"))
+				if attrs["src"] == "" || w < minImgTagSide || h < minImgTagSide {
+					continue
+				}
+				out = append(out, imageCandidate{url: attrs["src"], width: w, height: h, source: imageSourceImg})
+			}
+		}
+	}
+	for _, obj := range jsonLDObjects(htmlBody, ct) {
+		for _, key := range []string{"image", "thumbnailUrl"} {
+			out = append(out, jsonLDImages(obj[key])...)
+		}
+	}
+	return out
+}
+
+// jsonLDImages returns image candidates from JSON-LD image attribute, which
+// can be either an url, an ImageObject or a list of those.
+func jsonLDImages(v interface{}) []imageCandidate {
+	switch v := v.(type) {
+	case string:
+		if v != "" {
+			return []imageCandidate{{url: v, source: imageSourceJSONLD}}
+		}
+	case []interface{}:
+		var out []imageCandidate
+		for _, x := range v {
+			out = append(out, jsonLDImages(x)...)
+		}
+		return out
+	case map[string]interface{}:
+		u := jsonLDString(v, "url")
+		if u == "" {
+			u = jsonLDString(v, "contentUrl")
+		}
+		if u != "" {
+			return []imageCandidate{{
+				url:    u,
+				width:  jsonLDInt(v, "width"),
+				height: jsonLDInt(v, "height"),
+				source: imageSourceJSONLD,
+			}}
+		}
+	}
+	return nil
+}
+
+// needImageCandidates reports whether page has to be searched for image
+// candidates: parsers found no image or found one which must not be used,
+// image has to be validated, so that broken one can be replaced, or handler
+// is configured to report image candidates.
+func (h *unfurlHandler) needImageCandidates(result *unfurlResult) bool {
+	return result.Image == "" || h.ValidateImages || h.ImageCandidates > 0 ||
+		scoreImage(imageCandidate{
+			url:    result.Image,
+			width:  result.ImageWidth,
+			height: result.ImageHeight,
+			source: imageSourceGiven,
+		}) < 0
+}
+
+// pickImage selects the best preview image among candidates found in the page
+// and the image result already has, and sets it as result image. If handler
+// is configured to fetch image dimensions, candidates with unknown dimensions
+// are probed. If handler is configured to validate images, broken candidates
+// are skipped and true is returned. If handler is configured to report image
+// candidates, result images list is filled as well.
+func (h *unfurlHandler) pickImage(ctx context.Context, chunk *pageChunk, result *unfurlResult) (validated bool) {
+	var cands []imageCandidate
+	if result.Image != "" {
+		cands = append(cands, imageCandidate{
+			url:    result.Image,
+			width:  result.ImageWidth,
+			height: result.ImageHeight,
+			source: imageSourceGiven,
+		})
+	}
+	cands = append(cands, htmlImageCandidates(chunk.data, chunk.ct)...)
+	seen := make(map[string]int) // url to index in out
+	out := cands[:0]
+	for _, c := range cands {
+		u, err := absoluteImageURL(chunk.url.String(), c.url)
+		if err != nil || !validURL(u) {
+			continue
+		}
+		c.url = u
+		if i, ok := seen[u]; ok {
+			// same image may be declared multiple times, keep the
+			// most preferred source and any known dimensions
+			if out[i].width == 0 || out[i].height == 0 {
+				out[i].width, out[i].height = c.width, c.height
+			}
+			continue
+		}
+		seen[u] = len(out)
+		out = append(out, c)
+	}
+	cands = out
+	for i := range cands {
+		cands[i].score = scoreImage(cands[i])
+	}
+	sort.Stable(imagesByScore(cands))
+	if h.FetchImageSize {
+		probes := 0
+		for i := range cands {
+			if probes == maxImageProbes {
+				break
+			}
+			c := &cands[i]
+			if c.score < 0 || (c.width > 0 && c.height > 0) {
+				continue
+			}
+			probes++
+			if meta, err := h.probeImage(ctx, c.url); err == nil {
+				c.width, c.height = meta.width, meta.height
+				c.mime, c.size = meta.mime, meta.size
+				c.score = scoreImage(*c)
+			}
+		}
+		sort.Stable(imagesByScore(cands))
+	}
+	if h.ValidateImages {
+		for i := range cands {
+			c := &cands[i]
+			if c.score < 0 || i == maxImageProbes {
+				// the rest can't be used without validation
+				for j := i; j < len(cands); j++ {
+					cands[j].score = imageBlocked
+				}
+				break
+			}
+			meta, ok := h.validImage(ctx, c.url)
+			if !ok {
+				c.score = imageBlocked
+				continue
+			}
+			c.width, c.height = meta.width, meta.height
+			c.mime, c.size = meta.mime, meta.size
+			break
+		}
+		sort.Stable(imagesByScore(cands))
+	}
+	result.Image, result.ImageWidth, result.ImageHeight = "", 0, 0
+	result.ImageType, result.ImageSize = "", 0
+	if len(cands) > 0 && cands[0].score >= 0 {
+		result.Image = cands[0].url
+		result.ImageWidth, result.ImageHeight = cands[0].width, cands[0].height
+		result.ImageType, result.ImageSize = cands[0].mime, cands[0].size
+	}
+	if h.ImageCandidates > 0 {
+		result.Images = nil
+		for _, c := range cands {
+			if len(result.Images) == h.ImageCandidates || c.score < 0 {
+				break
+			}
+			result.Images = append(result.Images, imageInfo{URL: c.url, Width: c.width, Height: c.height})
+		}
+	}
+	return h.ValidateImages
+}
+
+type imagesByScore []imageCandidate
+
+func (s imagesByScore) Len() int           { return len(s) }
+func (s imagesByScore) Less(i, j int) bool { return s[i].score > s[j].score }
+func (s imagesByScore) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,103 @@
+package unfurlist
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestScoreImage(t *testing.T) {
+	testCases := []struct {
+		c       imageCandidate
+		blocked bool
+	}{
+		{imageCandidate{url: "https://example.com/a.jpg", source: imageSourceOG}, false},
+		{imageCandidate{url: "https://example.com/a.jpg", width: 1, height: 1, source: imageSourceOG}, true},
+		{imageCandidate{url: "https://www.facebook.com/tr?id=1&ev=PageView", source: imageSourceImg}, true},
+		{imageCandidate{url: "https://example.com/img/spacer.gif", source: imageSourceImageSrc}, true},
+		{imageCandidate{url: "https://example.com/a.jpg", width: 1200, height: 50, source: imageSourceOG}, true},
+		{imageCandidate{url: "https://stats.g.doubleclick.net/p.jpg", source: imageSourceImg}, true},
+		{imageCandidate{url: "https://example.com/open/1x1.gif?u=1", source: imageSourceImg}, true},
+		{imageCandidate{url: "https://example.com/track/open.gif", source: imageSourceImg}, true},
+		{imageCandidate{url: "https://example.com/blog/eye-tracking-study.jpg", source: imageSourceOG}, false},
+		{imageCandidate{url: "https://example.com/photos/1x1-meeting.jpg", source: imageSourceOG}, false},
+		{imageCandidate{url: "https://pixelfed.example.com/a.jpg", source: imageSourceOG}, false},
+	}
+	for _, tc := range testCases {
+		if got := scoreImage(tc.c) < 0; got != tc.blocked {
+			t.Errorf("scoreImage(%+v) blocked: %v, want %v", tc.c, got, tc.blocked)
+		}
+	}
+	large := imageCandidate{url: "https://example.com/photo.jpg", width: 1200, height: 630, source: imageSourceTwitter}
+	logo := imageCandidate{url: "https://example.com/logo.png", source: imageSourceOG}
+	banner := imageCandidate{url: "https://example.com/b.jpg", width: 1600, height: 200, source: imageSourceOG}
+	if scoreImage(large) <= scoreImage(logo) {
+		t.Errorf("large image should be preferred over logo")
+	}
+	if scoreImage(large) <= scoreImage(banner) {
+		t.Errorf("large image should be preferred over banner")
+	}
+	plain := scoreImage(imageCandidate{url: "https://example.com/a.jpg", source: imageSourceOG})
+	for _, u := range []string{
+		"https://example.com/silicon-defaults.jpg",
+		"https://example.com/sites/default/files/2024-01/photo.jpg",
+	} {
+		if scoreImage(imageCandidate{url: u, source: imageSourceOG}) != plain {
+			t.Errorf("image %q is penalized", u)
+		}
+	}
+	if scoreImage(imageCandidate{url: "https://example.com/img/default.png", source: imageSourceOG}) >= plain {
+		t.Errorf("default image is not penalized")
+	}
+}
+
+func TestNeedImageCandidates(t *testing.T) {
+	h := new(unfurlHandler)
+	if !h.needImageCandidates(&unfurlResult{}) {
+		t.Error("candidates not needed for result without image")
+	}
+	if h.needImageCandidates(&unfurlResult{Image: "https://example.com/a.jpg"}) {
+		t.Error("candidates needed for result with image")
+	}
+	if !h.needImageCandidates(&unfurlResult{Image: "https://example.com/spacer.gif"}) {
+		t.Error("candidates not needed for result with blocked image")
+	}
+	h.ImageCandidates = 3
+	if !h.needImageCandidates(&unfurlResult{Image: "https://example.com/a.jpg"}) {
+		t.Error("candidates not needed for handler reporting them")
+	}
+}
+
+func TestPickImage(t *testing.T) {
+	const page = `<html><head>
+<meta property="og:image" content="/logo.png">
+<meta property="og:image" content="/track/pixel.gif">
+<meta property="og:image:width" content="1">
+<meta property="og:image:height" content="1">
+<meta name="twitter:image" content="/cover.jpg">
+<script type="application/ld+json">
+{"@context":"https://schema.org","@graph":[{"@type":"Article",
+"image":{"@type":"ImageObject","url":"https://cdn.example.com/hero.jpg","width":1200,"height":630}}]}
+</script>
+</head><body>
+<img src="/small.png" width="16" height="16">
+<img src="/inline.jpg" width="400" height="300">
+</body></html>`
+	u, _ := url.Parse("https://example.com/article")
+	chunk := &pageChunk{data: []byte(page), url: u, ct: "text/html; charset=utf-8"}
+	h := &unfurlHandler{ImageCandidates: 3}
+	result := new(unfurlResult)
+	h.pickImage(context.Background(), chunk, result)
+	if result.Image != "https://cdn.example.com/hero.jpg" || result.ImageWidth != 1200 || result.ImageHeight != 630 {
+		t.Fatalf("unexpected image picked: %q (%dx%d)", result.Image, result.ImageWidth, result.ImageHeight)
+	}
+	want := []imageInfo{
+		{URL: "https://cdn.example.com/hero.jpg", Width: 1200, Height: 630},
+		{URL: "https://example.com/cover.jpg"},
+		{URL: "https://example.com/logo.png"},
+	}
+	if !reflect.DeepEqual(result.Images, want) {
+		t.Fatalf("got images:\n%+v\nwant:\n%+v", result.Images, want)
+	}
+}
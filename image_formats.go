@@ -0,0 +1,278 @@
+package unfurlist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // register supported image types
+	_ "image/jpeg"
+	_ "image/png"
+	"strconv"
+	"strings"
+)
+
+var errImageHeader = errors.New("cannot find image dimensions in header")
+
+// decodeImageHeader detects image format by its leading bytes and extracts
+// image dimensions. Content type is only used as a hint for svg images which
+// have no fixed signature.
+func decodeImageHeader(b []byte, ct string) (*imageMeta, error) {
+	var parse func([]byte) (int, int, error)
+	var mime string
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")),
+		bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")),
+		bytes.HasPrefix(b, []byte("GIF87a")),
+		bytes.HasPrefix(b, []byte("GIF89a")):
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return &imageMeta{width: cfg.Width, height: cfg.Height, mime: "image/" + format}, nil
+	case len(b) >= 12 && bytes.Equal(b[:4], []byte("RIFF")) && bytes.Equal(b[8:12], []byte("WEBP")):
+		parse, mime = webpDimensions, "image/webp"
+	case len(b) >= 12 && bytes.Equal(b[4:8], []byte("ftyp")) &&
+		(bytes.Equal(b[8:12], []byte("avif")) || bytes.Equal(b[8:12], []byte("avis"))):
+		parse, mime = avifDimensions, "image/avif"
+	case bytes.HasPrefix(b, []byte("BM")):
+		parse, mime = bmpDimensions, "image/bmp"
+	case bytes.HasPrefix(b, []byte("\x00\x00\x01\x00")):
+		parse, mime = icoDimensions, "image/x-icon"
+	case bytes.HasPrefix(b, []byte("II*\x00")), bytes.HasPrefix(b, []byte("MM\x00*")):
+		parse, mime = tiffDimensions, "image/tiff"
+	case strings.HasPrefix(ct, "image/svg") || looksLikeSVG(b):
+		parse, mime = svgDimensions, "image/svg+xml"
+	default:
+		return nil, fmt.Errorf("unsupported image format (content-type %q)", ct)
+	}
+	w, h, err := parse(b)
+	if err != nil {
+		return nil, err
+	}
+	if w <= 0 || h <= 0 {
+		return nil, errImageHeader
+	}
+	return &imageMeta{width: w, height: h, mime: mime}, nil
+}
+
+// webpDimensions parses dimensions of lossy (VP8), lossless (VP8L) and
+// extended (VP8X) WebP images, see
+// https://developers.google.com/speed/webp/docs/riff_container
+func webpDimensions(b []byte) (int, int, error) {
+	if len(b) < 30 {
+		return 0, 0, errImageHeader
+	}
+	switch string(b[12:16]) {
+	case "VP8 ":
+		// frame tag (3 bytes), start code (3 bytes), then 14-bit
+		// width and height
+		if !bytes.Equal(b[23:26], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, errImageHeader
+		}
+		w := int(binary.LittleEndian.Uint16(b[26:28]) & 0x3fff)
+		h := int(binary.LittleEndian.Uint16(b[28:30]) & 0x3fff)
+		return w, h, nil
+	case "VP8L":
+		if b[20] != 0x2f {
+			return 0, 0, errImageHeader
+		}
+		bits := binary.LittleEndian.Uint32(b[21:25])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	case "VP8X":
+		w := int(uint32(b[24]) | uint32(b[25])<<8 | uint32(b[26])<<16)
+		h := int(uint32(b[27]) | uint32(b[28])<<8 | uint32(b[29])<<16)
+		return w + 1, h + 1, nil
+	}
+	return 0, 0, errImageHeader
+}
+
+// avifDimensions looks up image spatial extents property ("ispe" box) of AVIF
+// image.
+func avifDimensions(b []byte) (int, int, error) {
+	// ispe box is: size(4) "ispe" version+flags(4) width(4) height(4);
+	// it's inside nested meta/iprp/ipco boxes, so just search for it
+	i := bytes.Index(b, []byte("ispe"))
+	if i < 0 || len(b) < i+16 {
+		return 0, 0, errImageHeader
+	}
+	w := binary.BigEndian.Uint32(b[i+8:])
+	h := binary.BigEndian.Uint32(b[i+12:])
+	return int(w), int(h), nil
+}
+
+// bmpDimensions parses dimensions of BMP image
+func bmpDimensions(b []byte) (int, int, error) {
+	if len(b) < 26 {
+		return 0, 0, errImageHeader
+	}
+	if binary.LittleEndian.Uint32(b[14:18]) == 12 { // BITMAPCOREHEADER
+		return int(binary.LittleEndian.Uint16(b[18:20])), int(binary.LittleEndian.Uint16(b[20:22])), nil
+	}
+	w := int32(binary.LittleEndian.Uint32(b[18:22]))
+	h := int32(binary.LittleEndian.Uint32(b[22:26]))
+	if h < 0 { // top-down bitmap
+		h = -h
+	}
+	return int(w), int(h), nil
+}
+
+// icoDimensions returns dimensions of the largest image in ICO file
+func icoDimensions(b []byte) (int, int, error) {
+	if len(b) < 6 {
+		return 0, 0, errImageHeader
+	}
+	var w, h int
+	count := int(binary.LittleEndian.Uint16(b[4:6]))
+	for i := 0; i < count; i++ {
+		off := 6 + i*16
+		if len(b) < off+16 {
+			break
+		}
+		// zero means 256 pixels
+		ew, eh := int(b[off]), int(b[off+1])
+		if ew == 0 {
+			ew = 256
+		}
+		if eh == 0 {
+			eh = 256
+		}
+		if ew*eh > w*h {
+			w, h = ew, eh
+		}
+	}
+	return w, h, nil
+}
+
+// tiffDimensions reads ImageWidth and ImageLength tags of the first image
+// file directory of TIFF image
+func tiffDimensions(b []byte) (int, int, error) {
+	if len(b) < 8 {
+		return 0, 0, errImageHeader
+	}
+	var bo binary.ByteOrder = binary.LittleEndian
+	if b[0] == 'M' {
+		bo = binary.BigEndian
+	}
+	off := int(bo.Uint32(b[4:8]))
+	if off < 8 || len(b) < off+2 {
+		return 0, 0, errImageHeader
+	}
+	var w, h int
+	n := int(bo.Uint16(b[off:]))
+	for i := 0; i < n; i++ {
+		e := off + 2 + i*12
+		if len(b) < e+12 {
+			break
+		}
+		var v int
+		switch bo.Uint16(b[e+2:]) { // field type
+		case 3: // SHORT
+			v = int(bo.Uint16(b[e+8:]))
+		case 4: // LONG
+			v = int(bo.Uint32(b[e+8:]))
+		default:
+			continue
+		}
+		switch bo.Uint16(b[e:]) {
+		case 256:
+			w = v
+		case 257:
+			h = v
+		}
+	}
+	return w, h, nil
+}
+
+// looksLikeSVG reports whether b starts with svg document, optionally
+// preceded by xml declaration, comments or doctype
+func looksLikeSVG(b []byte) bool {
+	if len(b) > 1024 {
+		b = b[:1024]
+	}
+	i := bytes.Index(b, []byte("<svg"))
+	if i < 0 {
+		return false
+	}
+	head := bytes.TrimSpace(b[:i])
+	return len(head) == 0 || head[0] == '<'
+}
+
+// svgDimensions returns dimensions of svg image from width and height
+// attributes of root element, falling back to viewBox if they're missing or
+// specified in relative units.
+func svgDimensions(b []byte) (int, int, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return 0, 0, errImageHeader
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if el.Name.Local != "svg" {
+			return 0, 0, errImageHeader
+		}
+		var w, h int
+		var viewBox string
+		for _, attr := range el.Attr {
+			switch attr.Name.Local {
+			case "width":
+				w = svgLength(attr.Value)
+			case "height":
+				h = svgLength(attr.Value)
+			case "viewBox":
+				viewBox = attr.Value
+			}
+		}
+		if w > 0 && h > 0 {
+			return w, h, nil
+		}
+		f := strings.FieldsFunc(viewBox, func(r rune) bool { return r == ',' || r == ' ' })
+		if len(f) != 4 {
+			return 0, 0, errImageHeader
+		}
+		vw, err1 := strconv.ParseFloat(f[2], 64)
+		vh, err2 := strconv.ParseFloat(f[3], 64)
+		if err1 != nil || err2 != nil || vw <= 0 || vh <= 0 {
+			return 0, 0, errImageHeader
+		}
+		switch {
+		case w > 0: // keep aspect ratio
+			return w, round(float64(w) * vh / vw), nil
+		case h > 0:
+			return round(float64(h) * vw / vh), h, nil
+		}
+		return round(vw), round(vh), nil
+	}
+}
+
+// svgLength parses absolute svg length like "100", "100px" or "1.5in" and
+// returns it in pixels. It returns 0 for relative lengths like "100%" or "2em".
+func svgLength(s string) int {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		px     float64
+	}{{"px", 1}, {"pt", 4.0 / 3}, {"pc", 16}, {"mm", 96 / 25.4}, {"cm", 96 / 2.54}, {"in", 96}}
+	scale := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, scale = strings.TrimSuffix(s, u.suffix), u.px
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0
+	}
+	return round(v * scale)
+}
+
+// round rounds positive f to the nearest integer
+func round(f float64) int { return int(f + 0.5) }
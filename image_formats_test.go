@@ -0,0 +1,96 @@
+package unfurlist
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeImageHeader(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+	webpX := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x00\x00\x00\x00")
+	webpX = append(webpX, 0x1f, 0x03, 0x00, 0x57, 0x02, 0x00) // 800x600
+	webpL := []byte("RIFF\x00\x00\x00\x00WEBPVP8L\x00\x00\x00\x00\x2f")
+	webpL = append(webpL, make([]byte, 9)...)
+	binary.LittleEndian.PutUint32(webpL[21:], 99|49<<14) // 100x50
+	bmp := make([]byte, 26)
+	copy(bmp, "BM")
+	binary.LittleEndian.PutUint32(bmp[14:], 40)
+	binary.LittleEndian.PutUint32(bmp[18:], 64)
+	binary.LittleEndian.PutUint32(bmp[22:], uint32(0xffffffff-31)) // -32, top-down
+	ico := []byte("\x00\x00\x01\x00\x02\x00")
+	ico = append(ico, 16, 16)
+	ico = append(ico, make([]byte, 14)...)
+	ico = append(ico, 0, 0) // 256x256
+	ico = append(ico, make([]byte, 14)...)
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x02\x00")
+	tiff = append(tiff, 0x00, 0x01, 0x03, 0x00, 1, 0, 0, 0, 0x40, 0x01, 0, 0) // width 320 SHORT
+	tiff = append(tiff, 0x01, 0x01, 0x04, 0x00, 1, 0, 0, 0, 0xf0, 0x00, 0, 0) // height 240 LONG
+	avif := []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf")
+	avif = append(avif, "\x00\x00\x00\x14ispe\x00\x00\x00\x00\x00\x00\x07\x80\x00\x00\x04\x38"...)
+
+	testCases := []struct {
+		name string
+		data []byte
+		ct   string
+		meta imageMeta
+	}{
+		{"png", pngData.Bytes(), "image/png", imageMeta{width: 30, height: 20, mime: "image/png"}},
+		{"webp VP8X", webpX, "image/webp", imageMeta{width: 800, height: 600, mime: "image/webp"}},
+		{"webp VP8L", webpL, "image/webp", imageMeta{width: 100, height: 50, mime: "image/webp"}},
+		{"bmp", bmp, "image/bmp", imageMeta{width: 64, height: 32, mime: "image/bmp"}},
+		{"ico", ico, "image/x-icon", imageMeta{width: 256, height: 256, mime: "image/x-icon"}},
+		{"tiff", tiff, "image/tiff", imageMeta{width: 320, height: 240, mime: "image/tiff"}},
+		{"avif", avif, "image/avif", imageMeta{width: 1920, height: 1080, mime: "image/avif"}},
+		{"svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" width="120px" height="60"/>`),
+			"image/svg+xml", imageMeta{width: 120, height: 60, mime: "image/svg+xml"}},
+		{"svg viewBox", []byte(`<svg viewBox="0 0 300 150"></svg>`),
+			"", imageMeta{width: 300, height: 150, mime: "image/svg+xml"}},
+		{"svg relative", []byte(`<svg width="100%" height="200" viewBox="0,0,400,100"></svg>`),
+			"image/svg+xml", imageMeta{width: 800, height: 200, mime: "image/svg+xml"}},
+	}
+	for _, tc := range testCases {
+		meta, err := decodeImageHeader(tc.data, tc.ct)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if *meta != tc.meta {
+			t.Errorf("%s: got %+v, want %+v", tc.name, *meta, tc.meta)
+		}
+	}
+	if _, err := decodeImageHeader([]byte("<html><body>not found</body></html>"), "text/html"); err == nil {
+		t.Error("html page decoded as image")
+	}
+}
+
+func TestImageDimensions(t *testing.T) {
+	data := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="40" height="30">` +
+		strings.Repeat(" ", maxImageHeaderSize) + `</svg>`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "" {
+			t.Error("request without Range header")
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		http.ServeContent(w, r, "image.svg", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+	meta, err := imageDimensions(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := imageMeta{width: 40, height: 30, mime: "image/svg+xml", size: int64(len(data))}
+	if *meta != want {
+		t.Fatalf("got %+v, want %+v", *meta, want)
+	}
+}
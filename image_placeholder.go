@@ -0,0 +1,400 @@
+package unfurlist
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	placeholderSize      = 32       // image is scaled down to this size before computing placeholder
+	blurHashX            = 4        // number of BlurHash horizontal components
+	blurHashY            = 3        // number of BlurHash vertical components
+	maxPlaceholderPixels = 4 << 20  // max dimensions of image decoded to compute placeholder
+	placeholderHeadSize  = 64 << 10 // size of image head read looking for exif thumbnail
+	placeholderCacheSize = 1024     // max number of placeholders memoized in process
+)
+
+// imagePlaceholder returns image properties along with its BlurHash and
+// dominant color. Unlike probeImage it downloads the image to decode it,
+// unless it is a jpeg with exif thumbnail, in which case only the thumbnail is
+// decoded. Results are cached together with results of probeImage and
+// memoized in process.
+func (h *unfurlHandler) imagePlaceholder(ctx context.Context, imageURL string) (*imageMeta, error) {
+	release, ok := h.acquire(ctx, "image:"+imageURL)
+	if !ok {
+		return nil, ctx.Err()
+	}
+	defer release()
+	if meta, ok := h.placeholders.get(imageURL); ok {
+		return meta, nil
+	}
+	cached, ok := h.imageCheckGet(imageURL)
+	if ok && (cached.Err != "" || cached.BlurHash != "") {
+		meta, err := cached.meta()
+		if err == nil {
+			h.placeholders.set(imageURL, meta, h.ImageCacheTTL)
+		}
+		return meta, err
+	}
+	meta, err := decodePlaceholder(ctx, h.fetchClient, imageURL)
+	if err != nil {
+		// image may be of format which can't be decoded, keep results
+		// of probeImage cached
+		return nil, err
+	}
+	if ok && cached.Mime != "" {
+		meta.mime = cached.Mime
+	}
+	h.imageCheckSet(imageURL, newImageCheck(meta, nil))
+	h.placeholders.set(imageURL, meta, h.ImageCacheTTL)
+	return meta, nil
+}
+
+// decodePlaceholder downloads and decodes image, computing its BlurHash and
+// dominant color. Only the head of jpeg images with exif thumbnail is read.
+// Images larger than maxPlaceholderPixels are rejected, decoding is limited
+// by imageWorkers semaphore. If provided client is nil, http.DefaultClient is
+// used.
+func decodePlaceholder(ctx context.Context, client *http.Client, imageURL string) (*imageMeta, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	if ct := strings.ToLower(resp.Header.Get("Content-Type")); !imageContentType(ct) {
+		return nil, fmt.Errorf("unsupported content-type %q", ct)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, placeholderHeadSize))
+	if err != nil {
+		return nil, err
+	}
+	size := resp.ContentLength
+	if len(data) < placeholderHeadSize {
+		size = int64(len(data))
+	}
+	// src is the image decoded: either exif thumbnail or the whole image
+	var src []byte
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && format == "jpeg" {
+		if thumb := exifThumbnail(data); thumb != nil {
+			// thumbnail may declare arbitrary dimensions
+			tc, err := jpeg.DecodeConfig(bytes.NewReader(thumb))
+			if err == nil && tc.Width*tc.Height <= maxPlaceholderPixels {
+				src = thumb
+			}
+		}
+	}
+	if src == nil {
+		if err == nil && cfg.Width*cfg.Height > maxPlaceholderPixels {
+			return nil, fmt.Errorf("image is too large to decode: %dx%d", cfg.Width, cfg.Height)
+		}
+		rest, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProxyImageSize+1-int64(len(data))))
+		if err != nil {
+			return nil, err
+		}
+		if data = append(data, rest...); len(data) > maxProxyImageSize {
+			return nil, errors.New("image is too large")
+		}
+		size = int64(len(data))
+		if cfg, format, err = image.DecodeConfig(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		if cfg.Width*cfg.Height > maxPlaceholderPixels {
+			return nil, fmt.Errorf("image is too large to decode: %dx%d", cfg.Width, cfg.Height)
+		}
+		src = data
+	}
+	select {
+	case imageWorkers <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	img, _, err := image.Decode(bytes.NewReader(src))
+	<-imageWorkers
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		size = 0
+	}
+	small := thumbnail(img, &thumbSpec{size: placeholderSize})
+	return &imageMeta{
+		width:    cfg.Width,
+		height:   cfg.Height,
+		mime:     "image/" + format,
+		size:     size,
+		blurHash: blurHash(small, blurHashX, blurHashY),
+		color:    dominantColor(small),
+	}, nil
+}
+
+// exifThumbnail returns jpeg thumbnail embedded into exif metadata of jpeg
+// image, or nil if there's none in data
+func exifThumbnail(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	for off := 2; off+4 <= len(data) && data[off] == 0xff; {
+		marker := data[off+1]
+		n := int(binary.BigEndian.Uint16(data[off+2:]))
+		if marker == 0xda || n < 2 || off+2+n > len(data) { // start of scan
+			return nil
+		}
+		seg := data[off+4 : off+2+n]
+		off += 2 + n
+		if marker != 0xe1 || !bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			continue
+		}
+		return tiffThumbnail(seg[6:])
+	}
+	return nil
+}
+
+// tiffThumbnail returns jpeg thumbnail referenced by the second IFD of tiff
+// structure of exif metadata
+func tiffThumbnail(tiff []byte) []byte {
+	if len(tiff) < 8 {
+		return nil
+	}
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil
+	}
+	ifd := int64(bo.Uint32(tiff[4:]))
+	// skip IFD0 to get to IFD1, which describes thumbnail
+	if ifd+2 > int64(len(tiff)) {
+		return nil
+	}
+	next := ifd + 2 + 12*int64(bo.Uint16(tiff[ifd:]))
+	if next+4 > int64(len(tiff)) {
+		return nil
+	}
+	ifd = int64(bo.Uint32(tiff[next:]))
+	if ifd == 0 || ifd+2 > int64(len(tiff)) {
+		return nil
+	}
+	var thumbOff, thumbLen int64
+	for i, n := int64(0), int64(bo.Uint16(tiff[ifd:])); i < n; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > int64(len(tiff)) {
+			return nil
+		}
+		switch bo.Uint16(tiff[e:]) {
+		case 0x0201: // JPEGInterchangeFormat
+			thumbOff = int64(bo.Uint32(tiff[e+8:]))
+		case 0x0202: // JPEGInterchangeFormatLength
+			thumbLen = int64(bo.Uint32(tiff[e+8:]))
+		}
+	}
+	if thumbOff <= 0 || thumbLen <= 0 || thumbOff+thumbLen > int64(len(tiff)) {
+		return nil
+	}
+	thumb := tiff[thumbOff : thumbOff+thumbLen]
+	if !bytes.HasPrefix(thumb, []byte{0xff, 0xd8}) {
+		return nil
+	}
+	return thumb
+}
+
+// placeholderCache memoizes image placeholders in process, so they are not
+// computed again for each unfurl if handler has no memcached configured. The
+// least recently used entries are evicted once cache holds size entries.
+type placeholderCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List // of *placeholderEntry, most recently used first
+	items map[string]*list.Element
+}
+
+type placeholderEntry struct {
+	url     string
+	meta    *imageMeta
+	expires time.Time
+}
+
+func newPlaceholderCache(size int) *placeholderCache {
+	return &placeholderCache{size: size, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *placeholderCache) get(imageURL string) (*imageMeta, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[imageURL]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*placeholderEntry)
+	if time.Now().After(e.expires) {
+		c.ll.Remove(el)
+		delete(c.items, imageURL)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	meta := *e.meta
+	return &meta, true
+}
+
+func (c *placeholderCache) set(imageURL string, meta *imageMeta, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &placeholderEntry{url: imageURL, meta: meta, expires: time.Now().Add(ttl)}
+	if el, ok := c.items[imageURL]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[imageURL] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*placeholderEntry).url)
+	}
+}
+
+// dominantColor returns the most frequent color of image in #rrggbb form.
+// Colors are grouped into buckets with 4 bits per channel, the average color
+// of the largest bucket is returned. Mostly transparent pixels are ignored.
+func dominantColor(img *image.RGBA) string {
+	type bucket struct{ r, g, b, n int }
+	var buckets [4096]bucket
+	best := -1
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		p := img.Pix[i : i+4 : i+4]
+		if p[3] < 128 {
+			continue
+		}
+		k := int(p[0]>>4)<<8 | int(p[1]>>4)<<4 | int(p[2]>>4)
+		b := &buckets[k]
+		b.r, b.g, b.b, b.n = b.r+int(p[0]), b.g+int(p[1]), b.b+int(p[2]), b.n+1
+		if best < 0 || b.n > buckets[best].n {
+			best = k
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	b := buckets[best]
+	return fmt.Sprintf("#%02x%02x%02x", b.r/b.n, b.g/b.n, b.b/b.n)
+}
+
+// blurHash encodes image with BlurHash algorithm using cx×cy components, see
+// https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+func blurHash(img *image.RGBA, cx, cy int) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w == 0 || h == 0 {
+		return ""
+	}
+	factors := make([][3]float64, 0, cx*cy)
+	for j := 0; j < cy; j++ {
+		for i := 0; i < cx; i++ {
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(w)) *
+						math.Cos(math.Pi*float64(j*y)/float64(h))
+					p := img.Pix[img.PixOffset(x, y):]
+					f[0] += basis * srgbToLinear(p[0])
+					f[1] += basis * srgbToLinear(p[1])
+					f[2] += basis * srgbToLinear(p[2])
+				}
+			}
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			scale := norm / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString(encode83((cx-1)+(cy-1)*9, 1))
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		var actualMax float64
+		for _, f := range ac {
+			for _, v := range f {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		buf.WriteString(encode83(quantisedMax, 1))
+	} else {
+		buf.WriteString(encode83(0, 1))
+	}
+	buf.WriteString(encode83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+	for _, f := range ac {
+		var q [3]int
+		for k, v := range f {
+			q[k] = int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		buf.WriteString(encode83(q[0]*19*19+q[1]*19+q[2], 2))
+	}
+	return buf.String()
+}
+
+const base83chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encode83(value, length int) string {
+	b := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = base83chars[value%83]
+		value /= 83
+	}
+	return string(b)
+}
+
+func srgbToLinear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
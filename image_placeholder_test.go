@@ -0,0 +1,139 @@
+package unfurlist
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestBlurHash(t *testing.T) {
+	testCases := []struct {
+		c    color.RGBA
+		want string
+	}{
+		{color.RGBA{0, 0, 0, 255}, "L00000fQfQfQfQfQfQfQfQfQfQfQ"},
+		{color.RGBA{255, 255, 255, 255}, "LfTSUA~qfQ~q~qt7fQt7fQfQfQfQ"},
+	}
+	for _, tc := range testCases {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		draw.Draw(img, img.Bounds(), image.NewUniform(tc.c), image.ZP, draw.Src)
+		if got := blurHash(img, 4, 3); got != tc.want {
+			t.Errorf("blurHash of %v: got %q, want %q", tc.c, got, tc.want)
+		}
+	}
+}
+
+func TestDominantColor(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0x3a, 0x6e, 0xa5, 0xff}), image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 3, 3), image.NewUniform(color.RGBA{0xff, 0, 0, 0xff}), image.ZP, draw.Src)
+	if got, want := dominantColor(img), "#3a6ea5"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestImagePlaceholder(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "missing token", http.StatusForbidden)
+			return
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0, 0x80, 0, 0xff}), image.ZP, draw.Src)
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, img)
+	}))
+	defer srv.Close()
+	h := newHandler(WithHTTPClient(srv.Client()), WithImagePlaceholders(true),
+		WithExtraHeaders(map[string]string{"X-Token": "secret"}))
+	meta, err := h.imagePlaceholder(context.Background(), srv.URL+"/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.width != 300 || meta.height != 200 || meta.color != "#008000" || len(meta.blurHash) != 28 {
+		t.Fatalf("unexpected result: %+v", meta)
+	}
+	if _, err := h.imagePlaceholder(context.Background(), srv.URL+"/a.png"); err != nil || requests != 1 {
+		t.Fatalf("placeholder was not memoized: %v, %d requests", err, requests)
+	}
+}
+
+func TestImagePlaceholder__exifThumbnail(t *testing.T) {
+	green := color.RGBA{0, 0x80, 0, 0xff}
+	encode := func(w, h int) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(img, img.Bounds(), image.NewUniform(green), image.ZP, draw.Src)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	large := encode(2100, 2100) // above maxPlaceholderPixels
+	thumb := encode(64, 64)
+
+	// little endian tiff: header, empty IFD0, IFD1 pointing to thumbnail
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = append(tiff, 0, 0, 14, 0, 0, 0) // IFD0: no entries, IFD1 at 14
+	tiff = append(tiff, 2, 0)
+	entry := func(tag uint16, v uint32) {
+		e := make([]byte, 12)
+		binary.LittleEndian.PutUint16(e, tag)
+		binary.LittleEndian.PutUint16(e[2:], 4) // LONG
+		binary.LittleEndian.PutUint32(e[4:], 1)
+		binary.LittleEndian.PutUint32(e[8:], v)
+		tiff = append(tiff, e...)
+	}
+	entry(0x0201, 14+2+2*12+4)
+	entry(0x0202, uint32(len(thumb)))
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, thumb...)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	data := []byte{0xff, 0xd8, 0xff, 0xe1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}
+	data = append(append(data, app1...), large[2:]...)
+
+	if got := exifThumbnail(data); !bytes.Equal(got, thumb) {
+		t.Fatalf("exif thumbnail not found, got %d bytes", len(got))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		switch r.URL.Path {
+		case "/exif.jpg":
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data)
+		default:
+			w.Write(large)
+		}
+	}))
+	defer srv.Close()
+	meta, err := decodePlaceholder(context.Background(), srv.Client(), srv.URL+"/exif.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.width != 2100 || meta.height != 2100 || meta.size != int64(len(data)) || len(meta.blurHash) != 28 {
+		t.Fatalf("unexpected result: %+v", meta)
+	}
+	if _, err := decodePlaceholder(context.Background(), srv.Client(), srv.URL+"/large.jpg"); err == nil {
+		t.Fatal("image above maxPlaceholderPixels was decoded")
+	}
+	// thumbnail declaring huge dimensions must not be decoded either
+	sof := bytes.Index(data, []byte{0xff, 0xc0})
+	if sof < 0 || sof > len(app1) {
+		t.Fatal("no SOF marker in thumbnail")
+	}
+	copy(data[sof+5:], []byte{0xff, 0xff, 0xff, 0xff})
+	if _, err := decodePlaceholder(context.Background(), srv.Client(), srv.URL+"/exif.jpg"); err == nil {
+		t.Fatal("image with huge exif thumbnail was decoded")
+	}
+}
@@ -0,0 +1,262 @@
+package unfurlist
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxProxyImageSize is the max size of image served by image proxy
+const maxProxyImageSize = 10 << 20
+
+// imageProxy generates and verifies signed image proxy urls of the form
+// base/hex(signature)/hex(url), where signature is HMAC-SHA256 of url.
+type imageProxy struct {
+	base string // base url of the proxy, without trailing slash
+	key  []byte
+}
+
+func (p *imageProxy) signature(imageURL string) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	io.WriteString(mac, imageURL)
+	return mac.Sum(nil)
+}
+
+// url returns signed proxy url for given image url
+func (p *imageProxy) url(imageURL string) string {
+	if imageURL == "" {
+		return ""
+	}
+	return p.base + "/" + hex.EncodeToString(p.signature(imageURL)) +
+		"/" + hex.EncodeToString([]byte(imageURL))
+}
+
+// imageURL extracts image url from the last two segments of proxy url path and
+// verifies its signature
+func (p *imageProxy) imageURL(path string) (string, error) {
+	parts := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	if len(parts) < 2 {
+		return "", errBadSignature
+	}
+	sig, err := hex.DecodeString(parts[len(parts)-2])
+	if err != nil {
+		return "", errBadSignature
+	}
+	u, err := hex.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return "", errBadSignature
+	}
+	if !hmac.Equal(sig, p.signature(string(u))) {
+		return "", errBadSignature
+	}
+	return string(u), nil
+}
+
+// rewrite replaces image and icon urls of result with proxy ones. Svg images
+// are kept as is, since proxy doesn't serve them.
+func (p *imageProxy) rewrite(r *unfurlResult) {
+	if !svgImage(r.Image, r.ImageType) {
+		r.Image = p.url(r.Image)
+	}
+	if !svgImage(r.IconUrl, r.IconType) {
+		r.IconUrl = p.url(r.IconUrl)
+	}
+	for i := range r.Images {
+		if !svgImage(r.Images[i].URL, "") {
+			r.Images[i].URL = p.url(r.Images[i].URL)
+		}
+	}
+}
+
+// svgImage reports whether image of given url and mime type, if known, is an
+// svg one
+func svgImage(imageURL, typ string) bool {
+	if strings.HasPrefix(strings.ToLower(typ), "image/svg") {
+		return true
+	}
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".svg", ".svgz":
+		return true
+	}
+	return false
+}
+
+var errBadSignature = errors.New("bad signature")
+
+// NewImageProxy returns http.Handler serving images by signed urls which unfurl
+// handler generates if it was configured with WithImageProxy using the same
+// key. Handler uses the same configuration functions as New, with http
+// client, extra headers and blacklisted prefixes being relevant ones.
+//
+// Handler only looks at the last two segments of the request path, so it can
+// be mounted under any prefix. Only images of raster formats up to 10MiB are
+// served; svg images are rejected since they may carry scripts.
+//
+// Thumbnails can be requested with query arguments: `s` is the size in pixels
+// (rounded up to one of 64, 160, 320, 640 or 1280), `m` is the mode, either
+// "fit" (default, fit image into s×s square) or "crop" (crop central square),
+// and `f` is the output format, "jpeg" or "png". Thumbnails are generated by
+// a limited number of workers and can be cached on disk, see
+// WithThumbnailCache. Jpeg, png and gif images can be resized, images of other
+// formats are served as is.
+func NewImageProxy(key []byte, conf ...ConfFunc) http.Handler {
+	return &imageProxyHandler{
+		h:     newHandler(conf...),
+		proxy: &imageProxy{key: key},
+	}
+}
+
+type imageProxyHandler struct {
+	h     *unfurlHandler
+	proxy *imageProxy
+}
+
+// proxiedHeaders are response headers passed from the origin to the client
+var proxiedHeaders = []string{"Cache-Control", "Expires", "Etag", "Last-Modified"}
+
+func (ph *imageProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	imageURL, err := ph.proxy.imageURL(r.URL.Path)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	h := ph.h
+	if !validURL(imageURL) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if h.pmap != nil && h.pmap.Match(imageURL) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	spec, err := parseThumbSpec(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if spec != nil && ph.serveCachedThumb(w, r, imageURL, spec) {
+		return
+	}
+	// conditional requests are only passed through for original images
+	resp, err := ph.fetch(r, imageURL, spec == nil)
+	if err != nil {
+		h.Log.Printf("image proxy %q: %v", imageURL, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	hdr := w.Header()
+	for _, k := range proxiedHeaders {
+		if v := resp.Header.Get(k); v != "" {
+			hdr.Set(k, v)
+		}
+	}
+	ph.setSecurityHeaders(hdr)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		w.WriteHeader(http.StatusNotModified)
+		return
+	case http.StatusNotFound, http.StatusGone:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	default:
+		h.Log.Printf("image proxy %q: bad status: %s", imageURL, resp.Status)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	ct, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(ct, "image/") || strings.HasPrefix(ct, "image/svg") {
+		h.Log.Printf("image proxy %q: unsupported content-type %q", imageURL, resp.Header.Get("Content-Type"))
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if resp.ContentLength > maxProxyImageSize {
+		h.Log.Printf("image proxy %q: image is too large: %d bytes", imageURL, resp.ContentLength)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if hdr.Get("Cache-Control") == "" && hdr.Get("Expires") == "" {
+		hdr.Set("Cache-Control", "public, max-age=86400")
+	}
+	if spec != nil {
+		ph.serveThumbnail(w, r, imageURL, resp, ct, spec)
+		return
+	}
+	hdr.Set("Content-Type", ct)
+	body := io.Reader(resp.Body)
+	if resp.ContentLength < 0 && r.Method != http.MethodHead {
+		// size is unknown, read image to make sure it's not too large
+		// before responding
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProxyImageSize+1))
+		if err != nil || len(data) > maxProxyImageSize {
+			h.Log.Printf("image proxy %q: read failed or image is too large: %v", imageURL, err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		body = bytes.NewReader(data)
+		hdr.Set("Content-Length", strconv.Itoa(len(data)))
+	} else if resp.ContentLength >= 0 {
+		hdr.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		h.Log.Printf("image proxy %q: %v", imageURL, err)
+	}
+}
+
+func (ph *imageProxyHandler) setSecurityHeaders(hdr http.Header) {
+	hdr.Set("X-Content-Type-Options", "nosniff")
+	hdr.Set("Content-Security-Policy", "default-src 'none'; img-src data:; style-src 'unsafe-inline'")
+}
+
+// fetch requests image, optionally passing conditional headers of the client
+// request through. Redirects are checked against handler blacklist in addition
+// to client redirect policy.
+func (ph *imageProxyHandler) fetch(r *http.Request, imageURL string, conditional bool) (*http.Response, error) {
+	h := ph.h
+	req, err := http.NewRequest(http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"If-None-Match", "If-Modified-Since"} {
+		if v := r.Header.Get(k); v != "" && conditional {
+			req.Header.Set(k, v)
+		}
+	}
+	req.Header.Set("Accept", "image/*")
+	// extra headers are added by fetchClient
+	client := h.fetchClient
+	cl := *client
+	cl.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if h.pmap != nil && h.pmap.Match(req.URL.String()) {
+			return errBlacklisted
+		}
+		return checkRedirect(client, req, via)
+	}
+	return cl.Do(req.WithContext(r.Context()))
+}
@@ -0,0 +1,111 @@
+package unfurlist
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImageProxy(t *testing.T) {
+	const pngData = "\x89PNG\r\n\x1a\nfake"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "max-age=600")
+		w.Header().Set("Set-Cookie", "tracking=1")
+		w.Write([]byte(pngData))
+	})
+	mux.HandleFunc("/a.svg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(`<svg><script>alert(1)</script></svg>`))
+	})
+	mux.HandleFunc("/huge.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.(http.Flusher).Flush() // no Content-Length
+		w.Write(make([]byte, maxProxyImageSize+1))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html></html>`))
+	})
+	origin := httptest.NewServer(mux)
+	defer origin.Close()
+
+	key := []byte("secret")
+	proxy := httptest.NewServer(http.StripPrefix("/image", NewImageProxy(key, WithHTTPClient(origin.Client()))))
+	defer proxy.Close()
+	signer := &imageProxy{base: proxy.URL + "/image", key: key}
+
+	resp, err := http.Get(signer.url(origin.URL + "/a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != pngData {
+		t.Fatalf("unexpected response: %s %q", resp.Status, body)
+	}
+	for k, want := range map[string]string{
+		"Content-Type":           "image/png",
+		"Cache-Control":          "max-age=600",
+		"X-Content-Type-Options": "nosniff",
+		"Set-Cookie":             "",
+	} {
+		if got := resp.Header.Get(k); got != want {
+			t.Errorf("%s header: got %q, want %q", k, got, want)
+		}
+	}
+
+	testCases := []struct {
+		url    string
+		status int
+	}{
+		{signer.url(origin.URL + "/a.svg"), http.StatusUnsupportedMediaType},
+		{signer.url(origin.URL + "/page"), http.StatusUnsupportedMediaType},
+		{signer.url(origin.URL + "/missing.png"), http.StatusNotFound},
+		{signer.url(origin.URL + "/huge.png"), http.StatusBadGateway},
+		{(&imageProxy{base: proxy.URL + "/image", key: []byte("other")}).url(origin.URL + "/a.png"), http.StatusForbidden},
+		{proxy.URL + "/image/zz/zz", http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		resp, err := http.Get(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: got status %d, want %d", tc.url, resp.StatusCode, tc.status)
+		}
+	}
+}
+
+func TestImageProxy__rewrite(t *testing.T) {
+	p := &imageProxy{base: "https://proxy.example.com/image", key: []byte("secret")}
+	r := &unfurlResult{Image: "https://example.com/a.png", Images: []imageInfo{{URL: "https://example.com/b.png"}}}
+	p.rewrite(r)
+	if r.IconUrl != "" {
+		t.Errorf("empty icon url rewritten to %q", r.IconUrl)
+	}
+	for _, u := range []string{r.Image, r.Images[0].URL} {
+		if !strings.HasPrefix(u, p.base+"/") {
+			t.Fatalf("url not rewritten: %q", u)
+		}
+	}
+	if u, err := p.imageURL(strings.TrimPrefix(r.Image, "https://proxy.example.com")); err != nil || u != "https://example.com/a.png" {
+		t.Fatalf("imageURL returned %q, %v", u, err)
+	}
+	r = &unfurlResult{
+		Image:     "https://example.com/logo",
+		ImageType: "image/svg+xml",
+		IconUrl:   "https://example.com/icon.SVG?v=2",
+		Images:    []imageInfo{{URL: "https://example.com/c.svgz"}},
+	}
+	p.rewrite(r)
+	for _, u := range []string{r.Image, r.IconUrl, r.Images[0].URL} {
+		if strings.HasPrefix(u, p.base+"/") {
+			t.Errorf("svg image url rewritten: %q", u)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package unfurlist
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// thumbSizes are the size classes thumbnails are generated for; requested size
+// is rounded up to the nearest class
+var thumbSizes = []int{64, 160, 320, 640, 1280}
+
+// maxSourcePixels limits dimensions of images decoded to generate thumbnails,
+// guarding against decompression bombs
+const maxSourcePixels = 40 << 20
+
+// imageWorkers is a semaphore limiting number of images decoded concurrently
+// by image proxy and for image placeholders
+var imageWorkers = make(chan struct{}, runtime.NumCPU())
+
+// thumbSpec describes thumbnail requested from image proxy with query
+// arguments: s (size in pixels), m (mode: fit or crop) and f (output format:
+// jpeg or png).
+type thumbSpec struct {
+	size   int
+	crop   bool
+	format string // jpeg, png or empty to pick based on source format
+}
+
+// parseThumbSpec parses thumbnail arguments of image proxy request. It returns
+// nil spec if no thumbnail was requested.
+func parseThumbSpec(q url.Values) (*thumbSpec, error) {
+	if q.Get("s") == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(q.Get("s"))
+	if err != nil || n <= 0 {
+		return nil, errors.New("invalid size")
+	}
+	spec := &thumbSpec{size: thumbSizes[len(thumbSizes)-1]}
+	for _, s := range thumbSizes {
+		if s >= n {
+			spec.size = s
+			break
+		}
+	}
+	switch q.Get("m") {
+	case "", "fit":
+	case "crop":
+		spec.crop = true
+	default:
+		return nil, errors.New("invalid mode")
+	}
+	switch f := q.Get("f"); f {
+	case "", "jpeg", "png":
+		spec.format = f
+	default:
+		return nil, errors.New("invalid format")
+	}
+	return spec, nil
+}
+
+// cacheName returns file name of thumbnail generated for given image url
+func (s *thumbSpec) cacheName(imageURL string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("%s|%d|%t|%s", imageURL, s.size, s.crop, s.format))))
+}
+
+// thumbnail scales image down according to spec; images are never scaled up.
+// In fit mode image is scaled to fit into size×size square keeping its aspect
+// ratio, in crop mode the central square of image is scaled to size×size.
+func thumbnail(src image.Image, spec *thumbSpec) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var dw, dh int
+	if spec.crop {
+		side := w
+		if h < side {
+			side = h
+		}
+		origin := b.Min.Add(image.Pt((w-side)/2, (h-side)/2))
+		b = image.Rectangle{Min: origin, Max: origin.Add(image.Pt(side, side))}
+		dw, dh = side, side
+		if side > spec.size {
+			dw, dh = spec.size, spec.size
+		}
+	} else {
+		dw, dh = w, h
+		if w > spec.size || h > spec.size {
+			if w >= h {
+				dw, dh = spec.size, max1(h*spec.size/w)
+			} else {
+				dw, dh = max1(w*spec.size/h), spec.size
+			}
+		}
+	}
+	return resizeImage(src, b, dw, dh)
+}
+
+// resizeImage scales r region of src down to dw×dh using area averaging.
+// Source is converted to RGBA one row at a time, so memory used doesn't
+// depend on source dimensions.
+func resizeImage(src image.Image, r image.Rectangle, dw, dh int) *image.RGBA {
+	sw, sh := r.Dx(), r.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	row := image.NewRGBA(image.Rect(0, 0, sw, 1))
+	sums := make([]uint32, dw*5) // r, g, b, a and number of pixels
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for i := range sums {
+			sums[i] = 0
+		}
+		for sy := y0; sy < y1; sy++ {
+			draw.Draw(row, row.Bounds(), src, image.Pt(r.Min.X, r.Min.Y+sy), draw.Src)
+			for x := 0; x < dw; x++ {
+				x0, x1 := x*sw/dw, (x+1)*sw/dw
+				if x1 <= x0 {
+					x1 = x0 + 1
+				}
+				s := sums[x*5 : x*5+5 : x*5+5]
+				for off := x0 * 4; off < x1*4; off += 4 {
+					p := row.Pix[off : off+4 : off+4]
+					s[0], s[1], s[2], s[3] = s[0]+uint32(p[0]), s[1]+uint32(p[1]), s[2]+uint32(p[2]), s[3]+uint32(p[3])
+					s[4]++
+				}
+			}
+		}
+		for x := 0; x < dw; x++ {
+			s := sums[x*5 : x*5+5 : x*5+5]
+			off := dst.PixOffset(x, y)
+			dst.Pix[off+0] = uint8(s[0] / s[4])
+			dst.Pix[off+1] = uint8(s[1] / s[4])
+			dst.Pix[off+2] = uint8(s[2] / s[4])
+			dst.Pix[off+3] = uint8(s[3] / s[4])
+		}
+	}
+	return dst
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// serveCachedThumb serves thumbnail from disk cache if it's there
+func (ph *imageProxyHandler) serveCachedThumb(w http.ResponseWriter, r *http.Request, imageURL string, spec *thumbSpec) bool {
+	f, err := ph.h.thumbs.open(spec.cacheName(imageURL))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(f, hdr); err != nil {
+		return false
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(hdr))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	ph.setSecurityHeaders(w.Header())
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+	return true
+}
+
+// serveThumbnail generates thumbnail from image response and serves it,
+// saving it to disk cache if handler was configured with one. Images which
+// cannot be decoded or are too large to decode are served as is.
+func (ph *imageProxyHandler) serveThumbnail(w http.ResponseWriter, r *http.Request, imageURL string, resp *http.Response, ct string, spec *thumbSpec) {
+	h := ph.h
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProxyImageSize+1))
+	if err != nil || len(data) > maxProxyImageSize {
+		h.Log.Printf("image proxy %q: read failed or image is too large: %v", imageURL, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	hdr := w.Header()
+	hdr.Del("Etag")
+	hdr.Del("Last-Modified")
+	serveOriginal := func() {
+		hdr.Set("Content-Type", ct)
+		hdr.Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		serveOriginal()
+		return
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		h.Log.Printf("image proxy %q: image is too large to resize: %dx%d", imageURL, cfg.Width, cfg.Height)
+		serveOriginal()
+		return
+	}
+	select {
+	case imageWorkers <- struct{}{}:
+		defer func() { <-imageWorkers }()
+	case <-r.Context().Done():
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		h.Log.Printf("image proxy %q: %v", imageURL, err)
+		serveOriginal()
+		return
+	}
+	outFormat := spec.format
+	if outFormat == "" {
+		outFormat = "jpeg"
+		if format == "png" || format == "gif" {
+			outFormat = "png"
+		}
+	}
+	var buf bytes.Buffer
+	thumb := thumbnail(img, spec)
+	if outFormat == "png" {
+		err = png.Encode(&buf, thumb)
+	} else {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		h.Log.Printf("image proxy %q: thumbnail encode: %v", imageURL, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if h.thumbs != nil {
+		if err := h.thumbs.put(spec.cacheName(imageURL), buf.Bytes()); err != nil {
+			h.Log.Printf("image proxy: thumbnail cache: %v", err)
+		}
+	}
+	hdr.Set("Content-Type", "image/"+outFormat)
+	hdr.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it to name, so readers never see partially written files
+func writeFileAtomic(name string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// maxThumbCacheSize is the default limit of total size of thumbnails kept in
+// disk cache
+const maxThumbCacheSize = 1 << 30
+
+// thumbCache keeps generated thumbnails in a directory. Once total size of
+// thumbnails exceeds the limit, the oldest ones are removed.
+type thumbCache struct {
+	dir   string
+	limit int64
+
+	mu   sync.Mutex
+	size int64 // total size of files, -1 if directory wasn't scanned yet
+}
+
+func newThumbCache(dir string, limit int64) *thumbCache {
+	return &thumbCache{dir: dir, limit: limit, size: -1}
+}
+
+// open opens cached thumbnail
+func (c *thumbCache) open(name string) (*os.File, error) {
+	if c == nil {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filepath.Join(c.dir, name))
+}
+
+// put saves thumbnail to cache, evicting the oldest ones if cache grows over
+// its limit
+func (c *thumbCache) put(name string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size < 0 {
+		if err := c.prune(c.limit); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(c.dir, name), data); err != nil {
+		return err
+	}
+	c.size += int64(len(data))
+	if c.size <= c.limit {
+		return nil
+	}
+	return c.prune(c.limit * 9 / 10)
+}
+
+// prune removes the oldest files of cache directory until their total size
+// is at most limit, and updates the size of cache. Must be called with c.mu
+// held.
+func (c *thumbCache) prune(limit int64) error {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, fi := range files {
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, fi := range files {
+		if size <= limit {
+			break
+		}
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), ".tmp-") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, fi.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		size -= fi.Size()
+	}
+	c.size = size
+	return nil
+}
@@ -0,0 +1,157 @@
+package unfurlist
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseThumbSpec(t *testing.T) {
+	testCases := []struct {
+		query string
+		spec  *thumbSpec
+		err   bool
+	}{
+		{"", nil, false},
+		{"s=100", &thumbSpec{size: 160}, false},
+		{"s=64&m=crop&f=png", &thumbSpec{size: 64, crop: true, format: "png"}, false},
+		{"s=5000&m=fit&f=jpeg", &thumbSpec{size: 1280, format: "jpeg"}, false},
+		{"s=-1", nil, true},
+		{"s=64&m=stretch", nil, true},
+		{"s=64&f=gif", nil, true},
+	}
+	for _, tc := range testCases {
+		q, _ := url.ParseQuery(tc.query)
+		spec, err := parseThumbSpec(q)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error: %v", tc.query, err)
+			continue
+		}
+		if (spec == nil) != (tc.spec == nil) || (spec != nil && *spec != *tc.spec) {
+			t.Errorf("%q: got %+v, want %+v", tc.query, spec, tc.spec)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	testCases := []struct {
+		spec thumbSpec
+		w, h int
+	}{
+		{thumbSpec{size: 160}, 160, 80},
+		{thumbSpec{size: 160, crop: true}, 160, 160},
+		{thumbSpec{size: 640}, 400, 200},
+		{thumbSpec{size: 640, crop: true}, 200, 200},
+	}
+	for _, tc := range testCases {
+		b := thumbnail(src, &tc.spec).Bounds()
+		if b.Dx() != tc.w || b.Dy() != tc.h {
+			t.Errorf("%+v: got %dx%d, want %dx%d", tc.spec, b.Dx(), b.Dy(), tc.w, tc.h)
+		}
+	}
+	// central square of red|green|blue stripes must be green
+	stripes := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for x := 0; x < 300; x++ {
+		c := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}[x/100]
+		for y := 0; y < 100; y++ {
+			stripes.SetRGBA(x, y, c)
+		}
+	}
+	if c := thumbnail(stripes, &thumbSpec{size: 64, crop: true}).RGBAAt(32, 32); c != (color.RGBA{0, 255, 0, 255}) {
+		t.Errorf("unexpected color of cropped thumbnail: %v", c)
+	}
+	ycc := image.NewYCbCr(image.Rect(10, 10, 1010, 510), image.YCbCrSubsampleRatio420)
+	for i := range ycc.Y {
+		ycc.Y[i] = 255
+	}
+	for i := range ycc.Cb {
+		ycc.Cb[i], ycc.Cr[i] = 128, 128
+	}
+	thumb := thumbnail(ycc, &thumbSpec{size: 100})
+	if b := thumb.Bounds(); b.Dx() != 100 || b.Dy() != 50 {
+		t.Errorf("unexpected size of thumbnail: %v", b)
+	}
+	if c := thumb.RGBAAt(99, 49); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("unexpected color of ycbcr image thumbnail: %v", c)
+	}
+}
+
+func TestThumbCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unfurlist-thumbs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "old"), make([]byte, 60), 0644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "old"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	c := newThumbCache(dir, 100)
+	if err := c.put("new", make([]byte, 30)); err != nil || c.size != 90 {
+		t.Fatalf("put: %v, cache size %d", err, c.size)
+	}
+	if err := c.put("newer", make([]byte, 30)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old")); !os.IsNotExist(err) {
+		t.Fatalf("the oldest thumbnail was not evicted: %v", err)
+	}
+	for _, name := range []string{"new", "newer"} {
+		f, err := c.open(name)
+		if err != nil {
+			t.Fatalf("thumbnail %q was evicted: %v", name, err)
+		}
+		f.Close()
+	}
+	if c.size != 60 {
+		t.Fatalf("unexpected cache size: %d", c.size)
+	}
+}
+
+func TestImageProxy__thumbnail(t *testing.T) {
+	requests := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, image.NewGray(image.Rect(0, 0, 800, 600)))
+	}))
+	defer origin.Close()
+	dir, err := ioutil.TempDir("", "unfurlist-thumbs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := []byte("secret")
+	proxy := httptest.NewServer(NewImageProxy(key, WithHTTPClient(origin.Client()), WithThumbnailCache(dir)))
+	defer proxy.Close()
+	u := (&imageProxy{base: proxy.URL, key: key}).url(origin.URL+"/a.png") + "?s=300&m=crop"
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, format, err := image.DecodeConfig(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != "png" || cfg.Width != 320 || cfg.Height != 320 {
+			t.Fatalf("unexpected thumbnail: %s %dx%d", format, cfg.Width, cfg.Height)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("thumbnail was not cached, origin got %d requests", requests)
+	}
+}
@@ -0,0 +1,115 @@
+package unfurlist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	defaultImageCacheTTL = 6 * time.Hour       // see WithImageCacheTTL
+	maxMemcacheTTL       = 30 * 24 * time.Hour // larger values are treated as unix time
+)
+
+// imageCheck is a cached result of image probe
+type imageCheck struct {
+	Width    int    `json:"w,omitempty"`
+	Height   int    `json:"h,omitempty"`
+	Mime     string `json:"t,omitempty"`
+	Size     int64  `json:"s,omitempty"`
+	BlurHash string `json:"b,omitempty"` // see imagePlaceholder
+	Color    string `json:"c,omitempty"` // see imagePlaceholder
+	Err      string `json:"e,omitempty"` // non-empty if image is broken
+}
+
+func (c *imageCheck) meta() (*imageMeta, error) {
+	if c.Err != "" {
+		return nil, errors.New(c.Err)
+	}
+	return &imageMeta{width: c.Width, height: c.Height, mime: c.Mime, size: c.Size,
+		blurHash: c.BlurHash, color: c.Color}, nil
+}
+
+func newImageCheck(meta *imageMeta, err error) *imageCheck {
+	if err != nil {
+		return &imageCheck{Err: err.Error()}
+	}
+	return &imageCheck{Width: meta.width, Height: meta.height, Mime: meta.mime, Size: meta.size,
+		BlurHash: meta.blurHash, Color: meta.color}
+}
+
+// imageCheckGet returns cached results of image probe if handler has memcached
+// configured
+func (h *unfurlHandler) imageCheckGet(imageURL string) (*imageCheck, bool) {
+	mc := h.Cache
+	if mc == nil {
+		return nil, false
+	}
+	it, err := mc.Get(mcKey("image:" + imageURL))
+	if err != nil {
+		return nil, false
+	}
+	c := new(imageCheck)
+	if err := json.Unmarshal(it.Value, c); err != nil {
+		return nil, false
+	}
+	return c, true
+}
+
+// imageCheckSet caches results of image probe if handler has memcached
+// configured
+func (h *unfurlHandler) imageCheckSet(imageURL string, c *imageCheck) {
+	mc := h.Cache
+	if mc == nil {
+		return
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	ttl := h.ImageCacheTTL
+	if ttl > maxMemcacheTTL {
+		ttl = maxMemcacheTTL
+	}
+	mc.Set(&memcache.Item{Key: mcKey("image:" + imageURL), Value: data, Expiration: int32(ttl / time.Second)})
+}
+
+// probeImage calls imageDimensions for given image url, caching its results
+// in memcached if handler has it configured. Of failures only those of broken
+// images are cached, see brokenImageError. Concurrent
+// probes of the same image are deduplicated, so pages sharing the same image
+// only cost a single probe.
+func (h *unfurlHandler) probeImage(ctx context.Context, imageURL string) (*imageMeta, error) {
+	release, ok := h.acquire(ctx, "image:"+imageURL)
+	if !ok {
+		return nil, ctx.Err()
+	}
+	defer release()
+	if c, ok := h.imageCheckGet(imageURL); ok {
+		return c.meta()
+	}
+	meta, err := imageDimensions(ctx, h.HTTPClient, imageURL)
+	if _, broken := err.(brokenImageError); err == nil || broken {
+		h.imageCheckSet(imageURL, newImageCheck(meta, err))
+	}
+	return meta, err
+}
+
+// validImage checks that image url leads to an actual image of reasonable
+// size: it responds with successful status and image content type, and both
+// dimensions of image are at least minImageSide pixels.
+func (h *unfurlHandler) validImage(ctx context.Context, imageURL string) (*imageMeta, bool) {
+	meta, err := h.probeImage(ctx, imageURL)
+	if err != nil {
+		h.Log.Printf("image %q validation: %v", imageURL, err)
+		return nil, false
+	}
+	if meta.width < minImageSide || meta.height < minImageSide {
+		h.Log.Printf("image %q validation: too small (%dx%d)", imageURL, meta.width, meta.height)
+		return nil, false
+	}
+	return meta, true
+}
@@ -0,0 +1,140 @@
+package unfurlist
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPickImage__validation(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gone.jpg", http.NotFound)
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><title>Sign in</title></html>"))
+	})
+	mux.HandleFunc("/tiny.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, image.NewGray(image.Rect(0, 0, 10, 10)))
+	})
+	mux.HandleFunc("/photo.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, image.NewGray(image.Rect(0, 0, 200, 150)))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	page := `<html><head>
+<meta property="og:image" content="/gone.jpg">
+<meta name="twitter:image" content="/login">
+<link rel="image_src" href="/tiny.png">
+<script type="application/ld+json">{"image":"/photo.png"}</script>
+</head></html>`
+	u, _ := url.Parse(srv.URL + "/article")
+	chunk := &pageChunk{data: []byte(page), url: u, ct: "text/html"}
+	h := newHandler(WithHTTPClient(srv.Client()), WithImageValidation(true))
+	result := new(unfurlResult)
+	if !h.pickImage(context.Background(), chunk, result) {
+		t.Fatal("pickImage reported image is not validated")
+	}
+	if want := srv.URL + "/photo.png"; result.Image != want {
+		t.Fatalf("got image %q, want %q", result.Image, want)
+	}
+	if result.ImageWidth != 200 || result.ImageHeight != 150 || result.ImageType != "image/png" {
+		t.Fatalf("unexpected image properties: %dx%d %q", result.ImageWidth, result.ImageHeight, result.ImageType)
+	}
+
+	result = new(unfurlResult)
+	chunk.data = []byte(`<html><head><meta property="og:image" content="/gone.jpg"></head></html>`)
+	h.pickImage(context.Background(), chunk, result)
+	if result.Image != "" {
+		t.Fatalf("broken image was not dropped: %q", result.Image)
+	}
+}
+
+func TestPickImage__validationLimit(t *testing.T) {
+	var probes int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes++
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	var page string
+	for i := 0; i < 10; i++ {
+		page += fmt.Sprintf(`<img src="/%d.jpg" width="400" height="300">`, i)
+	}
+	u, _ := url.Parse(srv.URL + "/article")
+	chunk := &pageChunk{data: []byte(page), url: u, ct: "text/html"}
+	h := newHandler(WithHTTPClient(srv.Client()), WithImageValidation(true))
+	result := new(unfurlResult)
+	h.pickImage(context.Background(), chunk, result)
+	if result.Image != "" || probes != maxImageProbes {
+		t.Fatalf("got image %q after %d probes", result.Image, probes)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	h := newHandler()
+	release, ok := h.acquire(context.Background(), "image:x")
+	if !ok {
+		t.Fatal("acquire of free key failed")
+	}
+	acquired := make(chan struct{})
+	go func() {
+		release, ok := h.acquire(context.Background(), "image:x")
+		if ok {
+			release()
+		}
+		close(acquired)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, ok := h.acquire(ctx, "image:x"); ok {
+		t.Fatal("acquired key which is in-flight")
+	}
+	select {
+	case <-acquired:
+		t.Fatal("acquired key which is in-flight")
+	default:
+	}
+	release()
+	<-acquired
+}
+
+func TestImageDimensions__brokenImage(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gone.jpg", http.NotFound)
+	mux.HandleFunc("/busy.jpg", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><title>Sign in</title></html>"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	testCases := []struct {
+		path   string
+		broken bool
+	}{
+		{"/gone.jpg", true},
+		{"/login", true},
+		{"/busy.jpg", false},
+	}
+	for _, tc := range testCases {
+		_, err := imageDimensions(context.Background(), srv.Client(), srv.URL+tc.path)
+		if err == nil {
+			t.Fatalf("%s: no error", tc.path)
+		}
+		if _, broken := err.(brokenImageError); broken != tc.broken {
+			t.Errorf("%s: %v, broken image: %t, want %t", tc.path, err, broken, tc.broken)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package unfurlist
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// jsonLDObjects returns JSON-LD objects embedded into html page with
+// <script type="application/ld+json"> tags. Top-level arrays and @graph
+// lists are flattened.
+func jsonLDObjects(htmlBody []byte, ct string) []map[string]interface{} {
+	bodyReader, err := charset.NewReader(bytes.NewReader(htmlBody), ct)
+	if err != nil {
+		return nil
+	}
+	var out []map[string]interface{}
+	var add func(v interface{})
+	add = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, x := range v {
+				add(x)
+			}
+		case map[string]interface{}:
+			out = append(out, v)
+			if g, ok := v["@graph"]; ok {
+				add(g)
+			}
+		}
+	}
+	z := html.NewTokenizer(bodyReader)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return out
+		case html.StartTagToken:
+			name, hasAttr := z.TagName()
+			if atom.Lookup(name) != atom.Script {
+				continue
+			}
+			var isLD bool
+			for hasAttr {
+				var k, v []byte
+				k, v, hasAttr = z.TagAttr()
+				if string(k) == "type" && strings.EqualFold(strings.TrimSpace(string(v)), "application/ld+json") {
+					isLD = true
+				}
+			}
+			if !isLD || z.Next() != html.TextToken {
+				continue
+			}
+			var v interface{}
+			if err := json.Unmarshal(z.Text(), &v); err == nil {
+				add(v)
+			}
+		}
+	}
+}
+
+// jsonLDString returns string value of JSON-LD object attribute; if value is
+// a nested object, its "name" attribute is used.
+func jsonLDString(obj map[string]interface{}, key string) string {
+	switch v := obj[key].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case map[string]interface{}:
+		return jsonLDString(v, "name")
+	case []interface{}:
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				return strings.TrimSpace(s)
+			}
+			if m, ok := x.(map[string]interface{}); ok {
+				if s := jsonLDString(m, "name"); s != "" {
+					return s
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// jsonLDInt returns integer value of JSON-LD object attribute, which may be
+// specified as a number, a string or a QuantitativeValue object
+func jsonLDInt(obj map[string]interface{}, key string) int {
+	switch v := obj[key].(type) {
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(v), "px"))
+		return n
+	case map[string]interface{}:
+		return jsonLDInt(v, "value")
+	}
+	return 0
+}
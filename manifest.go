@@ -0,0 +1,145 @@
+package unfurlist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	maxManifestSize = 64 * 1024      // max size of web app manifest to read
+	manifestTTL     = 24 * time.Hour // how long manifests are cached
+)
+
+// webManifest holds subset of Web App Manifest attributes, see
+// https://www.w3.org/TR/appmanifest/
+type webManifest struct {
+	Name       string         `json:"name,omitempty"`
+	ShortName  string         `json:"short_name,omitempty"`
+	ThemeColor string         `json:"theme_color,omitempty"`
+	Icons      []manifestIcon `json:"icons,omitempty"`
+}
+
+type manifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// siteName returns the name suitable to be used as a site name
+func (m *webManifest) siteName() string {
+	if m.ShortName != "" {
+		return m.ShortName
+	}
+	return m.Name
+}
+
+// iconCandidates returns manifest icons with urls resolved against manifest
+// url. Monochrome icons are skipped.
+func (m *webManifest) iconCandidates(base *url.URL) []iconCandidate {
+	var out []iconCandidate
+	for _, ic := range m.Icons {
+		if ic.Src == "" || (ic.Purpose != "" && !hasRel(ic.Purpose, "any") && !hasRel(ic.Purpose, "maskable")) {
+			continue
+		}
+		u, err := base.Parse(ic.Src)
+		if err != nil {
+			continue
+		}
+		c := iconCandidate{url: u.String(), typ: ic.Type, rel: "icon"}
+		c.sizes, c.any = parseIconSizes(ic.Sizes)
+		out = append(out, c)
+	}
+	return out
+}
+
+// cachedManifest is web app manifest cached for site origin along with url it
+// was fetched from
+type cachedManifest struct {
+	URL      string       `json:"url"`
+	Manifest *webManifest `json:"manifest"`
+}
+
+// fetchManifest retrieves web app manifest from given absolute url and
+// returns it along with its url. Manifests are cached by origin of the page
+// in memcached if handler has it configured, so pages of the same site share
+// the manifest even if they refer to it by different urls.
+func (h *unfurlHandler) fetchManifest(ctx context.Context, pageURL *url.URL, manifestURL string) (*webManifest, string, error) {
+	key := mcKey("manifest:" + pageURL.Scheme + "://" + pageURL.Host)
+	if mc := h.Cache; mc != nil {
+		if it, err := mc.Get(key); err == nil {
+			c := new(cachedManifest)
+			if err := json.Unmarshal(it.Value, c); err == nil && c.Manifest != nil && c.URL != "" {
+				return c.Manifest, c.URL, nil
+			}
+		}
+	}
+	resp, err := h.httpGet(ctx, manifestURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New("bad status: " + resp.Status)
+	}
+	if u := resp.Request.URL.String(); h.pmap != nil && h.pmap.Match(u) {
+		return nil, "", errors.New("blacklisted url: " + u)
+	}
+	if ct := strings.ToLower(resp.Header.Get("Content-Type")); strings.HasPrefix(ct, "text/html") {
+		return nil, "", errors.New("unexpected content-type: " + ct)
+	}
+	m := new(webManifest)
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(m); err != nil {
+		return nil, "", err
+	}
+	if mc := h.Cache; mc != nil {
+		if data, err := json.Marshal(&cachedManifest{URL: manifestURL, Manifest: m}); err == nil {
+			mc.Set(&memcache.Item{Key: key, Value: data, Expiration: int32(manifestTTL / time.Second)})
+		}
+	}
+	return m, manifestURL, nil
+}
+
+// applyManifest fetches web app manifest declared by page and fills site
+// name, theme color and icon candidates of the result from it. Blacklisted
+// manifests and icons are skipped.
+func (h *unfurlHandler) applyManifest(ctx context.Context, pageURL *url.URL, result *unfurlResult) {
+	if result.manifest == "" || pageURL == nil {
+		return
+	}
+	mu, err := pageURL.Parse(result.manifest)
+	if err != nil || !validURL(mu.String()) {
+		return
+	}
+	if h.pmap != nil && h.pmap.Match(mu.String()) {
+		return
+	}
+	m, manifestURL, err := h.fetchManifest(ctx, pageURL, mu.String())
+	if err != nil {
+		h.Log.Printf("manifest %q fetch: %v", mu, err)
+		return
+	}
+	if mu, err = url.Parse(manifestURL); err != nil {
+		return
+	}
+	if result.SiteName == "" {
+		result.SiteName = m.siteName()
+	}
+	if result.ThemeColor == "" {
+		result.ThemeColor = m.ThemeColor
+	}
+	for _, c := range m.iconCandidates(mu) {
+		if h.pmap != nil && h.pmap.Match(c.url) {
+			continue
+		}
+		result.icons = append(result.icons, c)
+	}
+}
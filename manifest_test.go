@@ -0,0 +1,73 @@
+package unfurlist
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestUnfurlist__manifest(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Page</title>
+		<link rel="icon" href="/favicon-16.png" sizes="16x16">
+		<link rel="manifest" href="/app/manifest.json"></head></html>`))
+	})
+	mux.HandleFunc("/app/manifest.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/manifest+json")
+		w.Write([]byte(`{"name": "Example Application", "short_name": "Example",
+		"theme_color": "#336699", "icons": [
+			{"src": "icon-mono.png", "sizes": "192x192", "purpose": "monochrome"},
+			{"src": "icon-192.png", "sizes": "192x192", "type": "image/png"}
+		]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	handler := New(WithManifests(true), WithIconSize(64))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/?content="+srv.URL+"/page", nil))
+	var res []unfurlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+		t.Fatalf("unexpected response: %q", w.Body.String())
+	}
+	if want := "Example"; res[0].SiteName != want {
+		t.Errorf("got site name %q, want %q", res[0].SiteName, want)
+	}
+	if want := "#336699"; res[0].ThemeColor != want {
+		t.Errorf("got theme color %q, want %q", res[0].ThemeColor, want)
+	}
+	if want := srv.URL + "/app/icon-192.png"; res[0].IconUrl != want || res[0].IconWidth != 192 {
+		t.Errorf("got icon %q (width %d), want %q", res[0].IconUrl, res[0].IconWidth, want)
+	}
+}
+
+func TestApplyManifest__blacklist(t *testing.T) {
+	var fetched int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		w.Header().Set("Content-Type", "application/manifest+json")
+		w.Write([]byte(`{"name": "Example", "icons": [
+			{"src": "/cdn/icon-192.png", "sizes": "192x192"},
+			{"src": "/icon-64.png", "sizes": "64x64"}
+		]}`))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL + "/page")
+	h := newHandler(WithManifests(true), WithBlacklistPrefixes([]string{srv.URL + "/private/", srv.URL + "/cdn/"}))
+	result := &unfurlResult{manifest: "/private/manifest.json"}
+	h.applyManifest(context.Background(), u, result)
+	if fetched != 0 || result.SiteName != "" {
+		t.Fatalf("blacklisted manifest was fetched")
+	}
+	result = &unfurlResult{manifest: "/manifest.json?v=2"}
+	h.applyManifest(context.Background(), u, result)
+	if len(result.icons) != 1 || result.icons[0].url != srv.URL+"/icon-64.png" {
+		t.Fatalf("unexpected icons: %+v", result.icons)
+	}
+}
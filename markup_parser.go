@@ -0,0 +1,511 @@
+package unfurlist
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Supported content formats, see parseLinks
+const (
+	formatText     = "text"
+	formatMarkdown = "markdown"
+	formatHTML     = "html"
+)
+
+// Link describes url found in content. If url was found as a part of markup
+// link (i.e. markdown [text](url) or html <a href="url">text</a>), Text holds
+// link text.
+type Link struct {
+	URL  string
+	Text string
+}
+
+// Match describes url found in content along with its position. Offset and
+// Length are measured in bytes, RuneOffset and RuneLength are the same values
+// measured in runes. For markup links position covers the whole link construct
+// (i.e. markdown [text](url) or html <a href="url">text</a>), not only url.
+type Match struct {
+	Link
+	Offset     int
+	Length     int
+	RuneOffset int
+	RuneLength int
+}
+
+// textSpan describes url found at content[start:end]; for markup links span
+// covers the whole link construct, not only url.
+type textSpan struct {
+	url, text  string
+	start, end int
+}
+
+// ParseMarkdownLinks extracts unique http/https links from markdown text:
+// inline and reference-style links, autolinks and bare urls. Content of
+// fenced code blocks and code spans is ignored.
+func ParseMarkdownLinks(content string) []Link {
+	return spansToLinks(uniqueSpans(markdownSpans(content, false), -1))
+}
+
+// ParseHTMLLinks extracts unique http/https links from html fragment: hrefs of
+// <a> tags along with their text and bare urls found in text. Content of
+// <pre>, <code>, <script> and <style> elements is ignored.
+func ParseHTMLLinks(content string) []Link {
+	return spansToLinks(uniqueSpans(htmlSpans(content, false), -1))
+}
+
+// ExtractURLs returns all urls found in plain text content in order of their
+// appearance, along with their positions. Urls are matched and cleaned the
+// same way as ParseURLs does, but duplicates are preserved.
+func ExtractURLs(content string) []Match { return spansToMatches(content, textSpans(content)) }
+
+// linkMatch is a unique link found in content: position of its first
+// occurrence along with positions of all its occurrences
+type linkMatch struct {
+	Match
+	all []Match // in order of appearance, including the first one
+}
+
+// parseLinks extracts up to maxItems unique links from content according to
+// its format; format may be either "text" (default if empty), "markdown" or
+// "html". Position of each link is the position of its first occurrence,
+// positions of all its occurrences are reported as well. If bare is true,
+// scheme-less urls are also extracted from text. If format is not supported,
+// ok is false.
+func parseLinks(content, format string, bare bool, maxItems int) (links []linkMatch, ok bool) {
+	var spans []textSpan
+	switch format {
+	case formatText, "":
+		spans = plainTextSpans(content, bare)
+	case formatMarkdown:
+		spans = markdownSpans(content, bare)
+	case formatHTML:
+		spans = htmlSpans(content, bare)
+	default:
+		return nil, false
+	}
+	return groupMatches(spansToMatches(content, spans), maxItems), true
+}
+
+// groupMatches groups matches by url, returning up to maxItems unique links in
+// order of their first occurrence. If maxItems is negative, all unique links
+// are returned. Matches must be sorted by their position.
+func groupMatches(matches []Match, maxItems int) []linkMatch {
+	var out []linkMatch
+	seen := make(map[string]int) // url to index in out
+	for _, m := range matches {
+		if i, ok := seen[m.URL]; ok {
+			out[i].all = append(out[i].all, m)
+			continue
+		}
+		if maxItems >= 0 && len(out) == maxItems {
+			continue
+		}
+		seen[m.URL] = len(out)
+		out = append(out, linkMatch{Match: m, all: []Match{m}})
+	}
+	return out
+}
+
+// spansToMatches converts spans found in content to matches; spans must be
+// sorted by their position.
+func spansToMatches(content string, spans []textSpan) []Match {
+	out := make([]Match, len(spans))
+	var pos, runes int // byte position and number of runes before it
+	for i, s := range spans {
+		runes += utf8.RuneCountInString(content[pos:s.start])
+		pos = s.start
+		out[i] = Match{
+			Link:       Link{URL: s.url, Text: s.text},
+			Offset:     s.start,
+			Length:     s.end - s.start,
+			RuneOffset: runes,
+			RuneLength: utf8.RuneCountInString(content[s.start:s.end]),
+		}
+	}
+	return out
+}
+
+func spansToLinks(spans []textSpan) []Link {
+	out := make([]Link, len(spans))
+	for i, s := range spans {
+		out[i] = Link{URL: s.url, Text: s.text}
+	}
+	return out
+}
+
+// uniqueSpans returns up to maxItems spans with unique urls, keeping the first
+// occurrence of each url. If maxItems is negative, all unique spans are
+// returned. Spans must be sorted by their position.
+func uniqueSpans(spans []textSpan, maxItems int) []textSpan {
+	out := spans[:0]
+	seen := make(map[string]struct{})
+	for _, s := range spans {
+		if maxItems >= 0 && len(out) == maxItems {
+			break
+		}
+		if _, ok := seen[s.url]; ok {
+			continue
+		}
+		out = append(out, s)
+		seen[s.url] = struct{}{}
+	}
+	return out
+}
+
+// plainTextSpans returns spans of urls found in plain text content; if bare is
+// true, scheme-less urls are also reported.
+func plainTextSpans(content string, bare bool) []textSpan {
+	if bare {
+		return textSpansBare(content)
+	}
+	return textSpans(content)
+}
+
+// markdownSpans returns spans of links found in markdown content, sorted by
+// their position.
+func markdownSpans(content string, bare bool) []textSpan {
+	masked := []byte(content)
+	maskMarkdownCode(masked)
+	refs := markdownRefDefinitions(masked)
+	var spans []textSpan
+	for i := 0; i < len(masked); i++ {
+		if masked[i] != '[' || (i > 0 && masked[i-1] == '\\') {
+			continue
+		}
+		start := i
+		if i > 0 && masked[i-1] == '!' {
+			start = i - 1
+		}
+		s, ok := markdownLinkAt(masked, start, i, refs)
+		if !ok {
+			continue
+		}
+		spans = append(spans, s)
+		blank(masked[s.start:s.end])
+		i = s.end - 1
+	}
+	// whatever urls are left are bare ones
+	spans = append(spans, plainTextSpans(string(masked), bare)...)
+	sort.Sort(spansByPos(spans))
+	for i := range spans {
+		spans[i].text = markdownPlainText(spans[i].text)
+	}
+	return spans
+}
+
+// markdownLinkAt tries to parse inline or reference link which label starts
+// with '[' at b[open]; start is the position of the whole construct which may
+// differ from open for images. Link text is returned as a raw markdown.
+func markdownLinkAt(b []byte, start, open int, refs map[string]string) (textSpan, bool) {
+	closing := matchingBracket(b, open)
+	if closing < 0 {
+		return textSpan{}, false
+	}
+	label := string(b[open+1 : closing])
+	// inline link: [text](url "title")
+	if closing+1 < len(b) && b[closing+1] == '(' {
+		dest, end, ok := markdownDestination(b, closing+2)
+		if !ok || !isHTTPURL(dest) {
+			return textSpan{}, false
+		}
+		return textSpan{url: dest, text: label, start: start, end: end}, true
+	}
+	// full or collapsed reference link: [text][ref], [text][]
+	if closing+1 < len(b) && b[closing+1] == '[' {
+		if c2 := bytes.IndexByte(b[closing+2:], ']'); c2 >= 0 {
+			ref := string(b[closing+2 : closing+2+c2])
+			if ref == "" {
+				ref = label
+			}
+			if dest, ok := refs[normalizeRefLabel(ref)]; ok {
+				return textSpan{url: dest, text: label, start: start, end: closing + 3 + c2}, true
+			}
+		}
+		return textSpan{}, false
+	}
+	// shortcut reference link: [ref]
+	if dest, ok := refs[normalizeRefLabel(label)]; ok {
+		return textSpan{url: dest, text: label, start: start, end: closing + 1}, true
+	}
+	return textSpan{}, false
+}
+
+// matchingBracket returns index of ']' matching '[' at b[open], taking nesting
+// into account (i.e. images inside links). Labels do not span multiple
+// paragraphs, so search stops on a blank line. It returns -1 if no match found.
+func matchingBracket(b []byte, open int) int {
+	depth := 0
+	for i := open; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				return i
+			}
+		case '\n':
+			if i+1 < len(b) && b[i+1] == '\n' {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+// markdownDestination parses link destination and optional title starting at
+// b[pos] (right after opening parenthesis) up to the closing parenthesis. It
+// returns destination and position right after the closing parenthesis.
+func markdownDestination(b []byte, pos int) (dest string, end int, ok bool) {
+	i := pos
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\n') {
+		i++
+	}
+	if i < len(b) && b[i] == '<' {
+		j := bytes.IndexAny(b[i:], ">\n")
+		if j < 0 || b[i+j] != '>' {
+			return "", 0, false
+		}
+		dest, i = string(b[i+1:i+j]), i+j+1
+	} else {
+		start, depth := i, 0
+	loop:
+		for ; i < len(b); i++ {
+			switch b[i] {
+			case '\\':
+				i++
+			case ' ', '\t', '\n':
+				break loop
+			case '(':
+				depth++
+			case ')':
+				if depth == 0 {
+					break loop
+				}
+				depth--
+			}
+		}
+		if i > len(b) {
+			i = len(b)
+		}
+		dest = string(b[start:i])
+	}
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\n') {
+		i++
+	}
+	if i < len(b) && (b[i] == '"' || b[i] == '\'' || b[i] == '(') {
+		delim := b[i]
+		if delim == '(' {
+			delim = ')'
+		}
+		j := bytes.IndexByte(b[i+1:], delim)
+		if j < 0 {
+			return "", 0, false
+		}
+		i += j + 2
+		for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\n') {
+			i++
+		}
+	}
+	if i >= len(b) || b[i] != ')' || dest == "" {
+		return "", 0, false
+	}
+	return dest, i + 1, true
+}
+
+var reRefDefinition = regexp.MustCompile(`(?m)^ {0,3}\[([^\]\n]+)\]:[ \t]*<?([^\s>]+)>?(?:[ \t]+(?:"[^"\n]*"|'[^'\n]*'|\([^)\n]*\)))?[ \t]*$`)
+
+// markdownRefDefinitions collects link reference definitions ([ref]: url) from
+// b and blanks them out, so their urls are not reported as bare ones.
+// Returned map is keyed by normalized label.
+func markdownRefDefinitions(b []byte) map[string]string {
+	refs := make(map[string]string)
+	for _, m := range reRefDefinition.FindAllSubmatchIndex(b, -1) {
+		label := normalizeRefLabel(string(b[m[2]:m[3]]))
+		if dest := string(b[m[4]:m[5]]); isHTTPURL(dest) {
+			if _, ok := refs[label]; !ok {
+				refs[label] = dest
+			}
+		}
+		blank(b[m[0]:m[1]])
+	}
+	return refs
+}
+
+func normalizeRefLabel(s string) string { return strings.ToLower(strings.Join(strings.Fields(s), " ")) }
+
+// maskMarkdownCode blanks out fenced code blocks and code spans.
+func maskMarkdownCode(b []byte) {
+	var fence []byte // opening fence if inside fenced block
+	for pos := 0; pos < len(b); {
+		end := bytes.IndexByte(b[pos:], '\n')
+		if end < 0 {
+			end = len(b)
+		} else {
+			end += pos + 1
+		}
+		line := b[pos:end]
+		trimmed := bytes.TrimLeft(line, " ")
+		isFence := len(line)-len(trimmed) < 4 &&
+			(bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~")))
+		switch {
+		case fence != nil:
+			if isFence && bytes.HasPrefix(trimmed, fence) &&
+				len(bytes.TrimSpace(bytes.TrimLeft(trimmed, string(fence[:1])))) == 0 {
+				fence = nil
+			}
+			blank(line)
+		case isFence:
+			n := len(trimmed) - len(bytes.TrimLeft(trimmed, string(trimmed[:1])))
+			fence = append([]byte(nil), trimmed[:n]...)
+			blank(line)
+		}
+		pos = end
+	}
+	for i := 0; i < len(b); i++ {
+		if b[i] != '`' {
+			continue
+		}
+		n := 1
+		for i+n < len(b) && b[i+n] == '`' {
+			n++
+		}
+		closing := -1
+		for j := i + n; j < len(b); j++ {
+			if b[j] != '`' {
+				continue
+			}
+			m := 1
+			for j+m < len(b) && b[j+m] == '`' {
+				m++
+			}
+			if m == n {
+				closing = j
+				break
+			}
+			j += m - 1
+		}
+		if closing < 0 {
+			i += n - 1
+			continue
+		}
+		blank(b[i : closing+n])
+		i = closing + n - 1
+	}
+}
+
+var reMarkdownImage = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+
+// markdownPlainText returns simplified plain text version of markdown link
+// label: nested images are replaced with their alt text and emphasis markers
+// are removed.
+func markdownPlainText(label string) string {
+	if label == "" {
+		return ""
+	}
+	label = reMarkdownImage.ReplaceAllString(label, "$1")
+	label = strings.NewReplacer("**", "", "__", "", "~~", "", "`", "").Replace(label)
+	return strings.Join(strings.Fields(label), " ")
+}
+
+// htmlSpans returns spans of links found in html content, sorted by their
+// position.
+func htmlSpans(content string, bare bool) []textSpan {
+	var spans []textSpan
+	var skipDepth int    // >0 when inside element which content is ignored
+	var anchor *textSpan // non-nil when inside <a href>
+	var text bytes.Buffer
+	z := html.NewTokenizer(strings.NewReader(content))
+	for pos := 0; ; {
+		tt := z.Next()
+		raw := z.Raw()
+		start := pos
+		pos += len(raw)
+		switch tt {
+		case html.ErrorToken:
+			if anchor != nil {
+				anchor.text = strings.Join(strings.Fields(text.String()), " ")
+				anchor.end = pos
+				spans = append(spans, *anchor)
+			}
+			sort.Sort(spansByPos(spans))
+			return spans
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Pre, atom.Code, atom.Script, atom.Style, atom.Textarea:
+				if tt == html.StartTagToken {
+					skipDepth++
+				}
+			case atom.A:
+				if anchor != nil || skipDepth > 0 {
+					continue
+				}
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					if string(k) == "href" && isHTTPURL(string(v)) {
+						anchor = &textSpan{url: strings.TrimSpace(string(v)), start: start}
+						text.Reset()
+					}
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Pre, atom.Code, atom.Script, atom.Style, atom.Textarea:
+				if skipDepth > 0 {
+					skipDepth--
+				}
+			case atom.A:
+				if anchor != nil {
+					anchor.text = strings.Join(strings.Fields(text.String()), " ")
+					anchor.end = pos
+					spans = append(spans, *anchor)
+					anchor = nil
+				}
+			}
+		case html.TextToken:
+			switch {
+			case skipDepth > 0:
+			case anchor != nil:
+				text.Write(z.Text())
+			default:
+				for _, s := range plainTextSpans(string(raw), bare) {
+					s.url = html.UnescapeString(s.url)
+					s.start += start
+					s.end += start
+					spans = append(spans, s)
+				}
+			}
+		}
+	}
+}
+
+// isHTTPURL reports whether s looks like an absolute http or https url.
+func isHTTPURL(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// blank replaces all bytes of b except newlines with spaces.
+func blank(b []byte) {
+	for i := range b {
+		if b[i] != '\n' {
+			b[i] = ' '
+		}
+	}
+}
+
+type spansByPos []textSpan
+
+func (s spansByPos) Len() int           { return len(s) }
+func (s spansByPos) Less(i, j int) bool { return s[i].start < s[j].start }
+func (s spansByPos) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
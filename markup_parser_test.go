@@ -0,0 +1,92 @@
+package unfurlist
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func ExampleParseMarkdownLinks() {
+	text := "See [the docs](https://example.com/docs \"Docs\") and [wiki][1],\n" +
+		"also [![badge](https://example.com/badge.svg)](https://ci.example.com/build)\n" +
+		"and https://example.com/bare.\n" +
+		"Code like `curl https://example.com/api` is skipped:\n\n" +
+		"```\nhttps://example.com/fenced\n```\n\n" +
+		"[1]: https://en.wikipedia.org/wiki/The_Avengers_(film)\n"
+	for _, l := range ParseMarkdownLinks(text) {
+		fmt.Printf("%s\t%q\n", l.URL, l.Text)
+	}
+	// Output:
+	// https://example.com/docs	"the docs"
+	// https://en.wikipedia.org/wiki/The_Avengers_(film)	"wiki"
+	// https://ci.example.com/build	"badge"
+	// https://example.com/bare	""
+}
+
+func TestParseHTMLLinks(t *testing.T) {
+	text := `<p>Read <a href="https://example.com/a?x=1&amp;y=2">this <b>article</b></a>,
+	also http://example.com/b?x=1&amp;y=2 <a href="/relative">rel</a></p>
+	<pre>https://example.com/pre</pre><code><a href="https://example.com/code">x</a></code>`
+	want := []Link{
+		{URL: "https://example.com/a?x=1&y=2", Text: "this article"},
+		{URL: "http://example.com/b?x=1&y=2"},
+	}
+	if got := ParseHTMLLinks(text); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseLinks_format(t *testing.T) {
+	content := "[label](https://example.com/)"
+	if _, ok := parseLinks(content, "rtf", false, -1); ok {
+		t.Fatal("unsupported format accepted")
+	}
+	testCases := []struct {
+		format    string
+		url, text string
+	}{
+		{"", "https://example.com/", ""},
+		{formatText, "https://example.com/", ""},
+		{formatMarkdown, "https://example.com/", "label"},
+	}
+	for _, tc := range testCases {
+		got, ok := parseLinks(content, tc.format, false, -1)
+		if !ok || len(got) != 1 || got[0].URL != tc.url || got[0].Text != tc.text {
+			t.Errorf("format %q: got %+v, want url %q with text %q", tc.format, got, tc.url, tc.text)
+		}
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	text := "Привет (see http://example.com/), http://example.com/ again"
+	want := []Match{
+		{Link: Link{URL: "http://example.com/"}, Offset: 18, Length: 19, RuneOffset: 12, RuneLength: 19},
+		{Link: Link{URL: "http://example.com/"}, Offset: 40, Length: 19, RuneOffset: 34, RuneLength: 19},
+	}
+	got := ExtractURLs(text)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for _, m := range got {
+		if s := text[m.Offset : m.Offset+m.Length]; s != m.URL {
+			t.Errorf("offsets point to %q, want %q", s, m.URL)
+		}
+	}
+}
+
+func TestParseLinks_offsets(t *testing.T) {
+	content := "Über [label](https://example.com/) and https://example.com/"
+	got, _ := parseLinks(content, formatMarkdown, false, -1)
+	if len(got) != 1 {
+		t.Fatalf("want single match, got %+v", got)
+	}
+	if s := content[got[0].Offset : got[0].Offset+got[0].Length]; s != "[label](https://example.com/)" {
+		t.Fatalf("unexpected span %q", s)
+	}
+	if got[0].RuneOffset != 5 || got[0].Offset != 6 {
+		t.Fatalf("unexpected offsets: %+v", got[0])
+	}
+	if all := got[0].all; len(all) != 2 || all[0] != got[0].Match || all[1].Offset != 40 || all[1].RuneOffset != 39 {
+		t.Fatalf("unexpected occurrences: %+v", all)
+	}
+}
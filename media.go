@@ -0,0 +1,968 @@
+package unfurlist
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	mediaMetaSize  = 1 << 20  // max size of metadata requested with Range request
+	maxMediaRanges = 2        // max number of Range requests made to find mp4 metadata
+	oggTailSize    = 64 << 10 // size of ogg file tail requested to find its duration
+)
+
+// mediaInfo holds properties of audio or video file
+type mediaInfo struct {
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	Codec    string  `json:"codec,omitempty"`
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+
+	kind string // "audio" or "video", empty if unknown
+}
+
+// mediaFormat returns format of audio or video container data starts with:
+// "mp4", "matroska", "mp3", "ogg" or "flac". It returns empty string if data
+// doesn't look like any of the supported formats.
+func mediaFormat(data []byte) string {
+	switch {
+	case len(data) >= 12 && isMP4Box(data):
+		return "mp4"
+	case bytes.HasPrefix(data, []byte("\x1a\x45\xdf\xa3")):
+		return "matroska"
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return "ogg"
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return "flac"
+	case bytes.HasPrefix(data, []byte("ID3")):
+		// id3 tags are mostly used with mp3, but flac files may have
+		// them too
+		if n := id3Size(data); n < len(data) && bytes.HasPrefix(data[n:], []byte("fLaC")) {
+			return "flac"
+		}
+		return "mp3"
+	}
+	if _, ok := mpegFrameHeader(data); ok {
+		return "mp3"
+	}
+	return ""
+}
+
+func isMP4Box(data []byte) bool {
+	switch string(data[4:8]) {
+	case "ftyp":
+		return !isImageFtyp(data)
+	case "moov", "mdat", "wide", "free":
+		return true
+	}
+	return false
+}
+
+// imageBrands are ftyp brands of HEIF and AVIF images, which are ISO base
+// media files like mp4 ones
+var imageBrands = map[string]bool{
+	"avif": true, "avis": true, "heic": true, "heix": true, "heim": true,
+	"heis": true, "hevc": true, "hevx": true, "hevm": true, "hevs": true,
+	"mif1": true, "mif2": true, "msf1": true, "miaf": true,
+}
+
+// isImageFtyp reports whether data starts with ftyp box having major or one
+// of compatible brands of HEIF or AVIF image
+func isImageFtyp(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size > len(data) || size < 16 {
+		size = len(data)
+	}
+	if imageBrands[string(data[8:12])] {
+		return true
+	}
+	// minor version is followed by compatible brands
+	for off := 16; off+4 <= size; off += 4 {
+		if imageBrands[string(data[off:off+4])] {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMedia fills result with properties of audio or video file of given
+// format, see mediaFormat
+func (h *unfurlHandler) applyMedia(ctx context.Context, chunk *pageChunk, result *unfurlResult, format string) {
+	var info *mediaInfo
+	switch format {
+	case "mp4":
+		info = h.mp4Info(ctx, chunk)
+	case "matroska":
+		info = parseMatroska(chunk.data)
+	case "mp3":
+		size, _ := strconv.ParseInt(chunk.header.Get("Content-Length"), 10, 64)
+		info = parseMP3(chunk.data, size)
+	case "flac":
+		info = parseFLAC(chunk.data)
+	case "ogg":
+		info = h.oggInfo(ctx, chunk)
+	default:
+		return
+	}
+	info.Duration = math.Floor(info.Duration*1000+0.5) / 1000
+	switch {
+	case info.kind != "":
+		result.Type = info.kind
+	case strings.HasPrefix(chunk.ct, "audio/"):
+		result.Type = "audio"
+	case format == "mp4" || format == "matroska":
+		result.Type = "video"
+	default:
+		result.Type = "audio"
+	}
+	if result.Title == "" {
+		result.Title = info.Title
+	}
+	if result.Title == "" {
+		result.Title = pathName(chunk.url)
+	}
+	if *info != (mediaInfo{kind: info.kind}) {
+		result.Media = info
+	}
+}
+
+// mediaCodecs maps mp4 sample entry types and matroska codec ids to codec
+// names
+var mediaCodecs = map[string]string{
+	"avc1": "h264", "avc3": "h264", "hvc1": "hevc", "hev1": "hevc",
+	"av01": "av1", "vp08": "vp8", "vp09": "vp9",
+	"mp4a": "aac", "Opus": "opus", "fLaC": "flac", ".mp3": "mp3",
+	"ac-3": "ac3", "ec-3": "eac3", "alac": "alac",
+
+	"V_MPEG4/ISO/AVC": "h264", "V_MPEGH/ISO/HEVC": "hevc", "V_AV1": "av1",
+	"V_VP8": "vp8", "V_VP9": "vp9", "V_THEORA": "theora",
+	"A_AAC": "aac", "A_OPUS": "opus", "A_VORBIS": "vorbis", "A_FLAC": "flac",
+	"A_MPEG/L3": "mp3", "A_AC3": "ac3", "A_EAC3": "eac3",
+}
+
+func codecName(id string) string {
+	id = strings.TrimSpace(id)
+	if name, ok := mediaCodecs[id]; ok {
+		return name
+	}
+	return id
+}
+
+// mp4Info parses moov box of mp4 (or QuickTime) file. Files written while
+// recording usually have moov box at the end, after media data; in such case
+// moov box is requested with Range request at the offset where media data
+// ends. The same is done if moov box is larger than the first chunk.
+func (h *unfurlHandler) mp4Info(ctx context.Context, chunk *pageChunk) *mediaInfo {
+	var moov []byte
+	data, base := chunk.data, int64(0)
+	for i := 0; ; i++ {
+		box, complete, next := mp4Moov(data)
+		if box != nil {
+			moov = box
+		}
+		if complete || next <= 0 || i == maxMediaRanges ||
+			next > math.MaxInt64-base-mediaMetaSize {
+			break
+		}
+		base += next
+		var err error
+		data, err = h.fetchRange(ctx, chunk.url.String(),
+			fmt.Sprintf("bytes=%d-%d", base, base+mediaMetaSize-1), mediaMetaSize)
+		if err != nil {
+			h.Log.Printf("mp4 %q metadata fetch: %v", chunk.url, err)
+			break
+		}
+	}
+	if moov == nil {
+		return new(mediaInfo)
+	}
+	return parseMoov(moov)
+}
+
+// mp4Moov looks for moov box among top level boxes of data. If moov box is
+// found but is truncated, its available part is returned and next is set to
+// its offset. If moov box is not found, next is set to offset of the first box
+// which is not fully in data, or -1 if there's none.
+func mp4Moov(data []byte) (moov []byte, complete bool, next int64) {
+	off, n := int64(0), int64(len(data))
+	for off+8 <= n {
+		size, hdr := int64(binary.BigEndian.Uint32(data[off:])), int64(8)
+		typ := string(data[off+4 : off+8])
+		switch size {
+		case 0: // box extends to the end of file
+			size = n - off
+		case 1:
+			if off+16 > n {
+				return nil, false, off
+			}
+			u := binary.BigEndian.Uint64(data[off+8:])
+			if u > math.MaxInt64 {
+				return nil, false, -1
+			}
+			size, hdr = int64(u), 16
+		}
+		if size < hdr || size > math.MaxInt64-off {
+			return nil, false, -1
+		}
+		end := off + size
+		if typ == "moov" {
+			if end <= n {
+				return data[off+hdr : end], true, -1
+			}
+			return data[off+hdr:], false, off
+		}
+		if end > n {
+			return nil, false, end
+		}
+		off = end
+	}
+	if off < n {
+		return nil, false, off
+	}
+	return nil, false, -1
+}
+
+// mp4Boxes calls fn for each box of b with box type and its payload. Payload
+// of the last box may be truncated.
+func mp4Boxes(b []byte, fn func(typ string, payload []byte)) {
+	for len(b) >= 8 {
+		size, hdr := uint64(binary.BigEndian.Uint32(b)), uint64(8)
+		typ := string(b[4:8])
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return
+			}
+			size, hdr = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < hdr {
+			return
+		}
+		if size > uint64(len(b)) {
+			size = uint64(len(b))
+		}
+		fn(typ, b[hdr:size])
+		b = b[size:]
+	}
+}
+
+func parseMoov(moov []byte) *mediaInfo {
+	info := new(mediaInfo)
+	var audioCodec string
+	mp4Boxes(moov, func(typ string, b []byte) {
+		switch typ {
+		case "mvhd":
+			var scale, duration uint64
+			switch {
+			case len(b) >= 20 && b[0] == 0:
+				scale = uint64(binary.BigEndian.Uint32(b[12:]))
+				duration = uint64(binary.BigEndian.Uint32(b[16:]))
+			case len(b) >= 32 && b[0] == 1:
+				scale = uint64(binary.BigEndian.Uint32(b[20:]))
+				duration = binary.BigEndian.Uint64(b[24:])
+			}
+			if scale > 0 {
+				info.Duration = float64(duration) / float64(scale)
+			}
+		case "trak":
+			handler, codec, width, height := parseTrak(b)
+			switch handler {
+			case "vide":
+				if info.kind != "video" {
+					info.kind = "video"
+					info.Codec, info.Width, info.Height = codec, width, height
+				}
+			case "soun":
+				if audioCodec == "" {
+					audioCodec = codec
+				}
+			}
+		case "udta":
+			parseUdta(b, info)
+		}
+	})
+	if info.kind == "" && audioCodec != "" {
+		info.kind = "audio"
+		info.Codec = audioCodec
+	}
+	return info
+}
+
+// parseTrak returns handler type, codec and dimensions of mp4 track
+func parseTrak(trak []byte) (handler, codec string, width, height int) {
+	var walk func(typ string, b []byte)
+	walk = func(typ string, b []byte) {
+		switch typ {
+		case "tkhd":
+			// width and height are the last fields, in 16.16 fixed
+			// point format
+			if len(b) >= 84 {
+				width = int(binary.BigEndian.Uint32(b[len(b)-8:]) >> 16)
+				height = int(binary.BigEndian.Uint32(b[len(b)-4:]) >> 16)
+			}
+		case "mdia", "minf", "stbl":
+			mp4Boxes(b, walk)
+		case "hdlr":
+			if len(b) >= 12 {
+				handler = string(b[8:12])
+			}
+		case "stsd":
+			if len(b) >= 16 {
+				codec = codecName(string(b[12:16]))
+			}
+		}
+	}
+	mp4Boxes(trak, walk)
+	return handler, codec, width, height
+}
+
+// parseUdta reads title and artist from iTunes-style metadata list or from
+// QuickTime user data
+func parseUdta(udta []byte, info *mediaInfo) {
+	set := func(typ, value string) {
+		value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+		switch {
+		case typ == "\xa9nam" && info.Title == "":
+			info.Title = value
+		case (typ == "\xa9ART" || typ == "aART") && info.Artist == "":
+			info.Artist = value
+		}
+	}
+	mp4Boxes(udta, func(typ string, b []byte) {
+		switch typ {
+		case "meta":
+			// meta is a full box in mp4, but not in QuickTime files
+			if len(b) >= 8 && string(b[4:8]) != "hdlr" {
+				b = b[4:]
+			}
+			mp4Boxes(b, func(typ string, b []byte) {
+				if typ != "ilst" {
+					return
+				}
+				mp4Boxes(b, func(key string, b []byte) {
+					mp4Boxes(b, func(typ string, b []byte) {
+						if typ == "data" && len(b) >= 8 {
+							set(key, string(b[8:]))
+						}
+					})
+				})
+			})
+		case "\xa9nam", "\xa9ART":
+			if len(b) >= 4 {
+				n := int(binary.BigEndian.Uint16(b))
+				if n > len(b)-4 {
+					n = len(b) - 4
+				}
+				set(typ, string(b[4:4+n]))
+			}
+		}
+	})
+}
+
+// matroska element ids
+const (
+	mkvSegment       = 0x18538067
+	mkvInfo          = 0x1549a966
+	mkvTimecodeScale = 0x2ad7b1
+	mkvDuration      = 0x4489
+	mkvTitle         = 0x7ba9
+	mkvTracks        = 0x1654ae6b
+	mkvTrackEntry    = 0xae
+	mkvTrackType     = 0x83
+	mkvCodecID       = 0x86
+	mkvVideo         = 0xe0
+	mkvPixelWidth    = 0xb0
+	mkvPixelHeight   = 0xba
+	mkvTags          = 0x1254c367
+	mkvTag           = 0x7373
+	mkvSimpleTag     = 0x67c8
+	mkvTagName       = 0x45a3
+	mkvTagString     = 0x4487
+	mkvCluster       = 0x1f43b675
+)
+
+// parseMatroska reads segment information, tracks and tags of matroska (or
+// webm) file. Only elements preceding the first cluster are looked at.
+func parseMatroska(data []byte) *mediaInfo {
+	info := new(mediaInfo)
+	var (
+		scale              = uint64(1000000) // nanoseconds
+		duration           float64
+		audioCodec         string
+		trackType          uint64
+		codec              string
+		width, height      int
+		tagName, tagString string
+		walk               func(b []byte) bool
+	)
+	walk = func(b []byte) bool {
+		for len(b) > 0 {
+			id, n := ebmlVint(b, true)
+			if n == 0 {
+				return false
+			}
+			size, m := ebmlVint(b[n:], false)
+			if m == 0 {
+				return false
+			}
+			b = b[n+m:]
+			if size == 1<<uint(7*m)-1 || size > uint64(len(b)) {
+				// unknown or truncated size
+				size = uint64(len(b))
+			}
+			v := b[:size]
+			b = b[size:]
+			switch id {
+			case mkvCluster:
+				return false
+			case mkvSegment, mkvInfo, mkvTracks, mkvVideo, mkvTags, mkvTag:
+				if !walk(v) {
+					return false
+				}
+			case mkvTrackEntry:
+				trackType, codec, width, height = 0, "", 0, 0
+				ok := walk(v)
+				switch {
+				case trackType == 1 && info.kind != "video":
+					info.kind = "video"
+					info.Codec, info.Width, info.Height = codec, width, height
+				case trackType == 2 && audioCodec == "":
+					audioCodec = codec
+				}
+				if !ok {
+					return false
+				}
+			case mkvSimpleTag:
+				tagName, tagString = "", ""
+				ok := walk(v)
+				switch strings.ToUpper(tagName) {
+				case "TITLE":
+					if info.Title == "" {
+						info.Title = tagString
+					}
+				case "ARTIST":
+					if info.Artist == "" {
+						info.Artist = tagString
+					}
+				}
+				if !ok {
+					return false
+				}
+			case mkvTimecodeScale:
+				if s := ebmlUint(v); s > 0 {
+					scale = s
+				}
+			case mkvDuration:
+				switch len(v) {
+				case 4:
+					duration = float64(math.Float32frombits(binary.BigEndian.Uint32(v)))
+				case 8:
+					duration = math.Float64frombits(binary.BigEndian.Uint64(v))
+				}
+			case mkvTitle:
+				info.Title = strings.TrimSpace(string(v))
+			case mkvTrackType:
+				trackType = ebmlUint(v)
+			case mkvCodecID:
+				codec = codecName(strings.TrimRight(string(v), "\x00"))
+			case mkvPixelWidth:
+				width = int(ebmlUint(v))
+			case mkvPixelHeight:
+				height = int(ebmlUint(v))
+			case mkvTagName:
+				tagName = string(v)
+			case mkvTagString:
+				tagString = strings.TrimSpace(string(v))
+			}
+		}
+		return true
+	}
+	walk(data)
+	info.Duration = duration * float64(scale) / 1e9
+	if info.kind == "" && audioCodec != "" {
+		info.kind = "audio"
+		info.Codec = audioCodec
+	}
+	return info
+}
+
+// ebmlVint decodes variable length integer used for ebml element ids and
+// sizes; length marker is kept for ids. It returns number of bytes read, which
+// is 0 on error.
+func ebmlVint(b []byte, id bool) (uint64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+	n := 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if len(b) < n {
+		return 0, 0
+	}
+	v := uint64(b[0])
+	if !id {
+		v &= 0xff >> uint(n)
+	}
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// parseMP3 reads id3v2 tag and duration from Xing or VBRI header of the first
+// frame of mp3 file. Files without such header are assumed to have constant
+// bitrate, their duration is estimated from size of the whole file, if known
+// (positive).
+func parseMP3(data []byte, size int64) *mediaInfo {
+	info := &mediaInfo{kind: "audio", Codec: "mp3"}
+	off := parseID3(data, info)
+	if off > len(data) {
+		return info
+	}
+	// skip padding some encoders put between tag and the first frame
+	for i := off; i < len(data) && i < off+4096; i++ {
+		fh, ok := mpegFrameHeader(data[i:])
+		if !ok {
+			continue
+		}
+		frame := data[i:]
+		var frames uint32
+		if x := 4 + fh.sideInfo; len(frame) >= x+12 {
+			if tag := string(frame[x : x+4]); (tag == "Xing" || tag == "Info") &&
+				binary.BigEndian.Uint32(frame[x+4:])&1 != 0 {
+				frames = binary.BigEndian.Uint32(frame[x+8:])
+			}
+		}
+		if frames == 0 && len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+			frames = binary.BigEndian.Uint32(frame[36+14:])
+		}
+		switch {
+		case info.Duration != 0:
+		case frames > 0:
+			info.Duration = float64(frames) * float64(fh.samples) / float64(fh.sampleRate)
+		case size > int64(i):
+			info.Duration = float64(size-int64(i)) * 8 / float64(fh.bitrate*1000)
+		}
+		break
+	}
+	return info
+}
+
+// mpegFrame describes header of mpeg audio layer III frame
+type mpegFrame struct {
+	bitrate    int // kbit/s
+	sampleRate int
+	samples    int // number of samples per frame
+	sideInfo   int // size of side information following the header
+}
+
+// mpegBitrates are bitrates (kbit/s) of layer III frames indexed by bitrate
+// index of the header, for MPEG 1 and MPEG 2/2.5
+var mpegBitrates = [2][15]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// mpegFrameHeader parses header of mpeg audio layer III frame
+func mpegFrameHeader(h []byte) (f mpegFrame, ok bool) {
+	if len(h) < 4 || h[0] != 0xff || h[1]&0xe0 != 0xe0 {
+		return f, false
+	}
+	version := h[1] >> 3 & 3 // 0: MPEG 2.5, 2: MPEG 2, 3: MPEG 1
+	layer := h[1] >> 1 & 3   // 1: layer III
+	bitrate, rate := h[2]>>4, h[2]>>2&3
+	if version == 1 || layer != 1 || bitrate == 0 || bitrate == 15 || rate == 3 {
+		return f, false
+	}
+	f.sampleRate = []int{44100, 48000, 32000}[rate]
+	mono := h[3]>>6 == 3
+	switch version {
+	case 3:
+		f.bitrate = mpegBitrates[0][bitrate]
+		f.samples, f.sideInfo = 1152, 32
+		if mono {
+			f.sideInfo = 17
+		}
+	default:
+		f.bitrate = mpegBitrates[1][bitrate]
+		f.sampleRate /= 4 - int(version)
+		f.samples, f.sideInfo = 576, 17
+		if mono {
+			f.sideInfo = 9
+		}
+	}
+	return f, true
+}
+
+// id3Size returns size of id3v2 tag at the beginning of data, or 0 if there's
+// no tag
+func id3Size(data []byte) int {
+	if len(data) < 10 || !bytes.HasPrefix(data, []byte("ID3")) {
+		return 0
+	}
+	n := 10 + syncsafe(data[6:10])
+	if data[5]&0x10 != 0 { // footer present
+		n += 10
+	}
+	return n
+}
+
+func syncsafe(b []byte) int {
+	var v int
+	for _, c := range b {
+		v = v<<7 | int(c&0x7f)
+	}
+	return v
+}
+
+// parseID3 reads title, artist and duration from id3v2 tag at the beginning
+// of data. It returns size of the tag, which may be larger than data.
+func parseID3(data []byte, info *mediaInfo) int {
+	size := id3Size(data)
+	if size == 0 {
+		return 0
+	}
+	version := data[3]
+	b := data[10:]
+	if size-10 < len(b) {
+		b = b[:size-10]
+	}
+	if data[5]&0x40 != 0 && len(b) >= 4 { // extended header
+		n := int(binary.BigEndian.Uint32(b)) + 4
+		if version >= 4 {
+			n = syncsafe(b[:4])
+		}
+		if n > len(b) {
+			return size
+		}
+		b = b[n:]
+	}
+	idLen, hdrLen := 4, 10
+	if version == 2 {
+		idLen, hdrLen = 3, 6
+	}
+	for len(b) >= hdrLen && b[0] != 0 {
+		id := string(b[:idLen])
+		var n int
+		switch version {
+		case 2:
+			n = int(b[3])<<16 | int(b[4])<<8 | int(b[5])
+		case 3:
+			n = int(binary.BigEndian.Uint32(b[4:]))
+		default:
+			n = syncsafe(b[4:8])
+		}
+		b = b[hdrLen:]
+		if n > len(b) {
+			n = len(b)
+		}
+		switch id {
+		case "TIT2", "TT2":
+			if info.Title == "" {
+				info.Title = id3Text(b[:n])
+			}
+		case "TPE1", "TP1":
+			if info.Artist == "" {
+				info.Artist = id3Text(b[:n])
+			}
+		case "TLEN", "TLE":
+			var ms int
+			if _, err := fmt.Sscan(id3Text(b[:n]), &ms); err == nil && ms > 0 {
+				info.Duration = float64(ms) / 1000
+			}
+		}
+		b = b[n:]
+	}
+	return size
+}
+
+// id3Text decodes value of id3v2 text frame; only the first of multiple
+// values is returned
+func id3Text(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	enc, b := b[0], b[1:]
+	var s string
+	switch enc {
+	case 1, 2: // UTF-16 with byte order mark, UTF-16BE
+		bigEndian := enc == 2
+		switch {
+		case len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe:
+			bigEndian, b = false, b[2:]
+		case len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff:
+			bigEndian, b = true, b[2:]
+		}
+		u := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			if bigEndian {
+				u = append(u, binary.BigEndian.Uint16(b[i:]))
+			} else {
+				u = append(u, binary.LittleEndian.Uint16(b[i:]))
+			}
+		}
+		s = string(utf16.Decode(u))
+	case 3:
+		s = string(b)
+	default:
+		s = latin1(b)
+	}
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// parseFLAC reads stream information and vorbis comment of flac file
+func parseFLAC(data []byte) *mediaInfo {
+	info := &mediaInfo{kind: "audio", Codec: "flac"}
+	if n := parseID3(data, info); n < len(data) {
+		data = data[n:]
+	} else {
+		return info
+	}
+	if !bytes.HasPrefix(data, []byte("fLaC")) {
+		return info
+	}
+	b := data[4:]
+	for len(b) >= 4 {
+		last, typ := b[0]&0x80 != 0, b[0]&0x7f
+		n := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		b = b[4:]
+		if n > len(b) {
+			n = len(b)
+		}
+		switch block := b[:n]; typ {
+		case 0: // STREAMINFO
+			if len(block) >= 18 {
+				rate := int(block[10])<<12 | int(block[11])<<4 | int(block[12])>>4
+				total := uint64(block[13]&0x0f)<<32 | uint64(binary.BigEndian.Uint32(block[14:]))
+				if rate > 0 {
+					info.Duration = float64(total) / float64(rate)
+				}
+			}
+		case 4: // VORBIS_COMMENT
+			vorbisComment(block, info)
+		}
+		if last {
+			break
+		}
+		b = b[n:]
+	}
+	return info
+}
+
+// vorbisComment reads title and artist from vorbis comment, which is used by
+// flac, vorbis and opus
+func vorbisComment(b []byte, info *mediaInfo) {
+	next := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return nil, false
+		}
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, true
+	}
+	if _, ok := next(); !ok { // vendor string
+		return
+	}
+	if len(b) < 4 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for i := uint32(0); i < count; i++ {
+		c, ok := next()
+		if !ok {
+			return
+		}
+		kv := strings.SplitN(string(c), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch v := strings.TrimSpace(kv[1]); strings.ToUpper(kv[0]) {
+		case "TITLE":
+			if info.Title == "" {
+				info.Title = v
+			}
+		case "ARTIST":
+			if info.Artist == "" {
+				info.Artist = v
+			}
+		}
+	}
+}
+
+// oggPage is a page of ogg bitstream; payload of the last page in data may be
+// truncated
+type oggPage struct {
+	flags    byte
+	granule  int64
+	serial   uint32
+	segments []byte // lacing values
+	payload  []byte
+}
+
+// oggPages parses consecutive ogg pages found at the beginning of data
+func oggPages(data []byte) []oggPage {
+	var pages []oggPage
+	for len(data) >= 27 && bytes.HasPrefix(data, []byte("OggS")) {
+		n := int(data[26])
+		if len(data) < 27+n {
+			break
+		}
+		p := oggPage{
+			flags:    data[5],
+			granule:  int64(binary.LittleEndian.Uint64(data[6:])),
+			serial:   binary.LittleEndian.Uint32(data[14:]),
+			segments: data[27 : 27+n],
+		}
+		size := 0
+		for _, s := range p.segments {
+			size += int(s)
+		}
+		data = data[27+n:]
+		if size > len(data) {
+			size = len(data)
+		}
+		p.payload, data = data[:size], data[size:]
+		pages = append(pages, p)
+	}
+	return pages
+}
+
+// oggPackets joins payloads of pages of logical stream with given serial
+// number into packets, returning up to max packets
+func oggPackets(pages []oggPage, serial uint32, max int) [][]byte {
+	var packets [][]byte
+	var cur []byte
+	for _, p := range pages {
+		if p.serial != serial {
+			continue
+		}
+		payload := p.payload
+		for _, s := range p.segments {
+			n := int(s)
+			if n > len(payload) {
+				n = len(payload)
+			}
+			cur, payload = append(cur, payload[:n]...), payload[n:]
+			if s < 255 {
+				if packets = append(packets, cur); len(packets) == max {
+					return packets
+				}
+				cur = nil
+			}
+		}
+	}
+	if cur != nil {
+		packets = append(packets, cur)
+	}
+	return packets
+}
+
+// oggLastGranule returns granule position of the last page of logical stream
+// with given serial number found in data
+func oggLastGranule(data []byte, serial uint32) (int64, bool) {
+	for end := len(data); ; {
+		i := bytes.LastIndex(data[:end], []byte("OggS"))
+		if i < 0 {
+			return 0, false
+		}
+		if p := data[i:]; len(p) >= 27 && binary.LittleEndian.Uint32(p[14:]) == serial {
+			// -1 means no packet finishes on the page
+			if g := int64(binary.LittleEndian.Uint64(p[6:])); g >= 0 {
+				return g, true
+			}
+		}
+		end = i
+	}
+}
+
+// oggInfo reads properties of the first logical stream of ogg file, which may
+// be vorbis, opus or theora. Duration is calculated from granule position of
+// the last page of the stream, which is requested with Range request unless
+// the whole file is in the chunk.
+func (h *unfurlHandler) oggInfo(ctx context.Context, chunk *pageChunk) *mediaInfo {
+	info := new(mediaInfo)
+	pages := oggPages(chunk.data)
+	if len(pages) == 0 {
+		return info
+	}
+	serial := pages[0].serial
+	packets := oggPackets(pages, serial, 2)
+	var rate, preSkip int64
+	if len(packets) > 0 {
+		switch p := packets[0]; {
+		case bytes.HasPrefix(p, []byte("\x01vorbis")) && len(p) >= 16:
+			info.kind, info.Codec = "audio", "vorbis"
+			rate = int64(binary.LittleEndian.Uint32(p[12:]))
+		case bytes.HasPrefix(p, []byte("OpusHead")) && len(p) >= 12:
+			info.kind, info.Codec = "audio", "opus"
+			rate, preSkip = 48000, int64(binary.LittleEndian.Uint16(p[10:]))
+		case bytes.HasPrefix(p, []byte("\x80theora")) && len(p) >= 20:
+			// granule position of theora streams is not a sample
+			// number, so duration is not calculated
+			info.kind, info.Codec = "video", "theora"
+			info.Width = int(p[14])<<16 | int(p[15])<<8 | int(p[16])
+			info.Height = int(p[17])<<16 | int(p[18])<<8 | int(p[19])
+		}
+	}
+	if len(packets) > 1 {
+		switch p := packets[1]; {
+		case bytes.HasPrefix(p, []byte("\x03vorbis")):
+			vorbisComment(p[7:], info)
+		case bytes.HasPrefix(p, []byte("OpusTags")):
+			vorbisComment(p[8:], info)
+		}
+	}
+	if rate == 0 {
+		return info
+	}
+	tail := chunk.data
+	if last := pages[len(pages)-1]; last.serial != serial || last.flags&0x04 == 0 { // not end of stream
+		var err error
+		if tail, err = h.fetchTail(ctx, chunk.url.String(), oggTailSize); err != nil {
+			h.Log.Printf("ogg %q tail fetch: %v", chunk.url, err)
+			return info
+		}
+	}
+	if g, ok := oggLastGranule(tail, serial); ok && g > preSkip {
+		info.Duration = float64(g-preSkip) / float64(rate)
+	}
+	return info
+}
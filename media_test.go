@@ -0,0 +1,265 @@
+package unfurlist
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestUnfurlist__mp4(t *testing.T) {
+	be32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, v)
+		return b
+	}
+	tkhd := make([]byte, 84)
+	copy(tkhd[76:], be32(1280<<16))
+	copy(tkhd[80:], be32(720<<16))
+	stsd := func(codec string) []byte {
+		return mp4Box("stsd", be32(0), be32(1), be32(16), []byte(codec), make([]byte, 4))
+	}
+	hdlr := func(typ string) []byte {
+		return mp4Box("hdlr", make([]byte, 8), []byte(typ), make([]byte, 12))
+	}
+	moov := mp4Box("moov",
+		mp4Box("mvhd", make([]byte, 12), be32(1000), be32(12345), make([]byte, 80)),
+		mp4Box("trak", mp4Box("tkhd", tkhd), mp4Box("mdia", hdlr("vide"),
+			mp4Box("minf", mp4Box("stbl", stsd("avc1"))))),
+		mp4Box("trak", mp4Box("tkhd", make([]byte, 84)), mp4Box("mdia", hdlr("soun"),
+			mp4Box("minf", mp4Box("stbl", stsd("mp4a"))))),
+		mp4Box("udta", mp4Box("meta", make([]byte, 4), hdlr("mdir"), mp4Box("ilst",
+			mp4Box("\xa9nam", mp4Box("data", be32(1), be32(0), []byte("Holiday"))),
+			mp4Box("\xa9ART", mp4Box("data", be32(1), be32(0), []byte("Jane Doe")))))),
+	)
+	// recorders write moov box after media data
+	data := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("isom"), be32(512), []byte("isomavc1")),
+		mp4Box("mdat", make([]byte, 3*defaultMaxBodyChunkSize)),
+		moov,
+	}, nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	w := httptest.NewRecorder()
+	New().ServeHTTP(w, httptest.NewRequest("GET", "/?content="+srv.URL+"/clip.mp4", nil))
+	var res []unfurlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+		t.Fatalf("unexpected response: %q", w.Body.String())
+	}
+	want := mediaInfo{Duration: 12.345, Width: 1280, Height: 720, Codec: "h264",
+		Title: "Holiday", Artist: "Jane Doe"}
+	if res[0].Type != "video" || res[0].Title != "Holiday" || res[0].Media == nil || *res[0].Media != want {
+		t.Fatalf("unexpected result: %q", w.Body.String())
+	}
+}
+
+func TestMP4Moov__largeBox(t *testing.T) {
+	// free box followed by mdat with 64-bit size overflowing offset
+	data := append(mp4Box("free"), 0, 0, 0, 1, 'm', 'd', 'a', 't',
+		0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfc)
+	data = append(data, make([]byte, 64)...)
+	if moov, complete, next := mp4Moov(data); moov != nil || complete || next != -1 {
+		t.Fatalf("got moov %v, complete %v, next %d", moov, complete, next)
+	}
+}
+
+func TestMediaFormat__imageBrands(t *testing.T) {
+	for _, brands := range []string{"avif\x00\x00\x00\x00mif1miaf", "mif1\x00\x00\x00\x00heicmif1"} {
+		data := append(mp4Box("ftyp", []byte(brands)), mp4Box("meta", make([]byte, 32))...)
+		if got := mediaFormat(data); got != "" {
+			t.Errorf("mediaFormat returned %q for image with brands %q", got, brands)
+		}
+		if !isImageFtyp(data) {
+			t.Errorf("image with brands %q is not detected", brands)
+		}
+	}
+	data := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomavc1mp41"))
+	if got := mediaFormat(data); got != "mp4" {
+		t.Errorf("mediaFormat returned %q for mp4", got)
+	}
+}
+
+func TestParseMatroska(t *testing.T) {
+	f64 := make([]byte, 8)
+	binary.BigEndian.PutUint64(f64, math.Float64bits(5000))
+	data := bytes.Join([][]byte{
+		ebmlElement([]byte{0x1a, 0x45, 0xdf, 0xa3}, ebmlElement([]byte{0x42, 0x82}, []byte("webm"))),
+		// segment of unknown size
+		{0x18, 0x53, 0x80, 0x67, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		ebmlElement([]byte{0x15, 0x49, 0xa9, 0x66},
+			ebmlElement([]byte{0x2a, 0xd7, 0xb1}, []byte{0x0f, 0x42, 0x40}),
+			ebmlElement([]byte{0x44, 0x89}, f64),
+			ebmlElement([]byte{0x7b, 0xa9}, []byte("Clip")),
+		),
+		ebmlElement([]byte{0x16, 0x54, 0xae, 0x6b},
+			ebmlElement([]byte{0xae},
+				ebmlElement([]byte{0x83}, []byte{2}),
+				ebmlElement([]byte{0x86}, []byte("A_OPUS")),
+			),
+			ebmlElement([]byte{0xae},
+				ebmlElement([]byte{0x83}, []byte{1}),
+				ebmlElement([]byte{0x86}, []byte("V_VP9")),
+				ebmlElement([]byte{0xe0},
+					ebmlElement([]byte{0xb0}, []byte{0x02, 0x80}),
+					ebmlElement([]byte{0xba}, []byte{0x01, 0x68}),
+				),
+			),
+		),
+		ebmlElement([]byte{0x1f, 0x43, 0xb6, 0x75}, make([]byte, 100)),
+	}, nil)
+	if got := mediaFormat(data); got != "matroska" {
+		t.Fatalf("mediaFormat returned %q", got)
+	}
+	info := parseMatroska(data)
+	want := mediaInfo{Duration: 5, Width: 640, Height: 360, Codec: "vp9", Title: "Clip", kind: "video"}
+	if *info != want {
+		t.Fatalf("got %+v, want %+v", *info, want)
+	}
+}
+
+func TestParseMP3(t *testing.T) {
+	frames := bytes.Join([][]byte{
+		id3Frame("TIT2", append([]byte{0}, "Song"...)),
+		id3Frame("TPE1", []byte{1, 0xff, 0xfe, 'B', 0, 'j', 0, 0xf6, 0, 'r', 0, 'k', 0}),
+	}, nil)
+	tag := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(frames))}, frames...)
+	// MPEG-1 layer III, 128 kbit/s, 44100 Hz, stereo frame with Xing header
+	frame := append([]byte{0xff, 0xfb, 0x90, 0x64}, make([]byte, 32)...)
+	frame = append(frame, "Xing\x00\x00\x00\x01\x00\x00\x00\x64"...)
+	data := append(append(tag, frame...), make([]byte, 400)...)
+	if got := mediaFormat(data); got != "mp3" {
+		t.Fatalf("mediaFormat returned %q", got)
+	}
+	info := parseMP3(data, 1<<20)
+	info.Duration = math.Floor(info.Duration*1000+0.5) / 1000
+	want := mediaInfo{Duration: 2.612, Codec: "mp3", Title: "Song", Artist: "Björk", kind: "audio"}
+	if *info != want {
+		t.Fatalf("got %+v, want %+v", *info, want)
+	}
+
+	// constant bitrate file without Xing header: 128 kbit/s is 16000
+	// bytes per second
+	cbr := append(append(tag, 0xff, 0xfb, 0x90, 0x64), make([]byte, 400)...)
+	info = parseMP3(cbr, int64(len(tag))+16000*60)
+	want.Duration = 60
+	if *info != want {
+		t.Fatalf("got %+v, want %+v", *info, want)
+	}
+	if info = parseMP3(cbr, 0); info.Duration != 0 {
+		t.Fatalf("duration of file of unknown size: %v", info.Duration)
+	}
+}
+
+func TestParseFLAC(t *testing.T) {
+	streamInfo := make([]byte, 34)
+	// 44100 Hz, 2 channels, 16 bits per sample, 441000 samples
+	copy(streamInfo[10:], []byte{0x0a, 0xc4, 0x42, 0xf0, 0x00, 0x06, 0xba, 0xa8})
+	comment := vorbisCommentData("TITLE=Track", "artist=Band")
+	data := []byte("fLaC")
+	data = append(data, 0, 0, 0, byte(len(streamInfo)))
+	data = append(data, streamInfo...)
+	data = append(data, 0x84, 0, 0, byte(len(comment)))
+	data = append(data, comment...)
+	if got := mediaFormat(data); got != "flac" {
+		t.Fatalf("mediaFormat returned %q", got)
+	}
+	info := parseFLAC(data)
+	want := mediaInfo{Duration: 10, Codec: "flac", Title: "Track", Artist: "Band", kind: "audio"}
+	if *info != want {
+		t.Fatalf("got %+v, want %+v", *info, want)
+	}
+}
+
+func TestOggInfo__tail(t *testing.T) {
+	const serial = 42
+	head := append([]byte("OpusHead\x01\x02"), 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0)
+	var data []byte
+	data = append(data, oggTestPage(0x02, 0, serial, head)...)
+	data = append(data, oggTestPage(0, 0, serial,
+		append([]byte("OpusTags"), vorbisCommentData("TITLE=Podcast")...))...)
+	for i := 0; i < 2*defaultMaxBodyChunkSize/4000; i++ {
+		data = append(data, oggTestPage(0, int64(i*960), serial, make([]byte, 4000))...)
+	}
+	// 3 seconds plus pre-skip of 312 samples
+	data = append(data, oggTestPage(0x04, 3*48000+312, serial, make([]byte, 100))...)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/ogg")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/episode.opus")
+	chunk := &pageChunk{data: data[:defaultMaxBodyChunkSize], url: u, ct: "audio/ogg"}
+	info := newHandler().oggInfo(context.Background(), chunk)
+	want := mediaInfo{Duration: 3, Codec: "opus", Title: "Podcast", kind: "audio"}
+	if *info != want {
+		t.Fatalf("got %+v, want %+v", *info, want)
+	}
+}
+
+func mp4Box(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+	copy(b[4:], typ)
+	return append(b, payload...)
+}
+
+func ebmlElement(id []byte, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	b := append([]byte{}, id...)
+	b = append(b, 0x40|byte(len(payload)>>8), byte(len(payload)))
+	return append(b, payload...)
+}
+
+func id3Frame(id string, payload []byte) []byte {
+	b := make([]byte, 10, 10+len(payload))
+	copy(b, id)
+	binary.BigEndian.PutUint32(b[4:], uint32(len(payload)))
+	return append(b, payload...)
+}
+
+func vorbisCommentData(comments ...string) []byte {
+	var buf bytes.Buffer
+	for _, s := range append([]string{"vendor"}, comments...) {
+		if buf.Len() == 4+len("vendor") {
+			binary.Write(&buf, binary.LittleEndian, uint32(len(comments)))
+		}
+		binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	return buf.Bytes()
+}
+
+func oggTestPage(flags byte, granule int64, serial uint32, payload []byte) []byte {
+	hdr := make([]byte, 27)
+	copy(hdr, "OggS")
+	hdr[5] = flags
+	binary.LittleEndian.PutUint64(hdr[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(hdr[14:], serial)
+	var lacing []byte
+	n := len(payload)
+	for ; n >= 255; n -= 255 {
+		lacing = append(lacing, 255)
+	}
+	lacing = append(lacing, byte(n))
+	hdr[26] = byte(len(lacing))
+	return append(append(hdr, lacing...), payload...)
+}
+
+func TestCodecName(t *testing.T) {
+	for in, want := range map[string]string{"avc1": "h264", " mp4a ": "aac", "V_VP9": "vp9", "A_PCM/INT/LIT": "A_PCM/INT/LIT"} {
+		if got := codecName(in); got != want {
+			t.Errorf("codecName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package unfurlist
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	officeBudget    = 512 << 10 // max number of bytes read from office document
+	officeTailSize  = 64 << 10  // size of file tail holding zip central directory
+	officeReadAhead = 16 << 10  // min size of Range request
+	maxOfficeEntry  = 256 << 10 // max uncompressed size of metadata entry
+)
+
+// officeExtensions are extensions of OOXML and OpenDocument files
+var officeExtensions = map[string]bool{
+	".docx": true, ".xlsx": true, ".pptx": true,
+	".odt": true, ".ods": true, ".odp": true,
+}
+
+// isOfficeDocument reports whether resource is an Office Open XML or
+// OpenDocument file
+func isOfficeDocument(chunk *pageChunk, mimeType string) bool {
+	switch {
+	case strings.HasPrefix(mimeType, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument."):
+		return true
+	case mimeType == "application/zip", mimeType == "application/octet-stream":
+		return officeExtensions[strings.ToLower(path.Ext(chunk.url.Path))]
+	}
+	return false
+}
+
+// officeMeta holds office document metadata
+type officeMeta struct {
+	title    string
+	author   string
+	created  time.Time
+	modified time.Time
+	pages    int
+	slides   int
+}
+
+// applyOffice fills result with metadata of office document. Documents are zip
+// archives, so only their central directory and metadata entries are read
+// using Range requests, unless the whole document is in the chunk.
+func (h *unfurlHandler) applyOffice(ctx context.Context, chunk *pageChunk, result *unfurlResult) {
+	size, err := strconv.ParseInt(chunk.header.Get("Content-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		return
+	}
+	var r io.ReaderAt = bytes.NewReader(chunk.data)
+	if int64(len(chunk.data)) < size {
+		r = &rangeReaderAt{ctx: ctx, h: h, url: chunk.url.String(), size: size, budget: officeBudget}
+	}
+	meta, err := readOfficeMeta(r, size)
+	if err != nil {
+		h.Log.Printf("office document %q: %v", chunk.url, err)
+		return
+	}
+	result.Type = "document"
+	if meta.title != "" {
+		result.Title = meta.title
+	}
+	result.Author = meta.author
+	if !meta.created.IsZero() {
+		result.Created = meta.created.Format(time.RFC3339)
+	}
+	if !meta.modified.IsZero() {
+		result.Modified = meta.modified.UTC().Format(time.RFC3339)
+	}
+	result.PageCount, result.SlideCount = meta.pages, meta.slides
+}
+
+// readOfficeMeta reads metadata entries of office document: docProps/core.xml
+// and docProps/app.xml of OOXML files, meta.xml of OpenDocument files
+func readOfficeMeta(r io.ReaderAt, size int64) (*officeMeta, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	meta := new(officeMeta)
+	var found bool
+	for _, f := range zr.File {
+		switch f.Name {
+		case "docProps/core.xml", "docProps/app.xml", "meta.xml":
+		default:
+			continue
+		}
+		if f.UncompressedSize64 > maxOfficeEntry {
+			return nil, fmt.Errorf("%s is too large", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = parseOfficeXML(io.LimitReader(rc, maxOfficeEntry), meta)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		return nil, errors.New("no metadata found")
+	}
+	return meta, nil
+}
+
+// parseOfficeXML reads metadata from one of OOXML or OpenDocument metadata
+// files; elements are matched by their local names, as both formats use
+// Dublin Core for common properties
+func parseOfficeXML(r io.Reader, meta *officeMeta) error {
+	d := xml.NewDecoder(r)
+	var name string // local name of current element
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+			if name != "document-statistic" {
+				continue
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "page-count" {
+					meta.pages, _ = strconv.Atoi(attr.Value)
+				}
+			}
+		case xml.EndElement:
+			name = ""
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" {
+				continue
+			}
+			switch name {
+			case "title":
+				meta.title = text
+			case "creator", "initial-creator":
+				if meta.author == "" {
+					meta.author = text
+				}
+			case "created", "creation-date":
+				meta.created = parseXMPDate(text)
+			case "modified", "date":
+				meta.modified = parseXMPDate(text)
+			case "Pages":
+				meta.pages, _ = strconv.Atoi(text)
+			case "Slides":
+				meta.slides, _ = strconv.Atoi(text)
+			}
+		}
+	}
+}
+
+// rangeReaderAt implements io.ReaderAt over remote resource of known size
+// using Range requests. Fetched ranges are kept in memory; the first read
+// fetches the tail of the resource, where zip central directory is. Total
+// number of bytes fetched is limited by budget.
+type rangeReaderAt struct {
+	ctx    context.Context
+	h      *unfurlHandler
+	url    string
+	size   int64
+	budget int64
+	ranges []fetchedRange
+}
+
+type fetchedRange struct {
+	off  int64
+	data []byte
+}
+
+var errBudgetExceeded = errors.New("read budget exceeded")
+
+func (r *rangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+	for _, fr := range r.ranges {
+		if off >= fr.off && end <= fr.off+int64(len(fr.data)) {
+			n := copy(p, fr.data[off-fr.off:end-fr.off])
+			if n < len(p) {
+				return n, io.EOF
+			}
+			return n, nil
+		}
+	}
+	start, stop := off, end
+	if len(r.ranges) == 0 && r.size-start < officeTailSize {
+		start = r.size - officeTailSize
+		if start < 0 {
+			start = 0
+		}
+	}
+	if stop-start < officeReadAhead {
+		stop = start + officeReadAhead
+		if stop > r.size {
+			stop = r.size
+		}
+	}
+	if stop-start > r.budget {
+		return 0, errBudgetExceeded
+	}
+	data, err := r.h.fetchRange(r.ctx, r.url, fmt.Sprintf("bytes=%d-%d", start, stop-1), stop-start)
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(data)) != stop-start {
+		return 0, io.ErrUnexpectedEOF
+	}
+	r.budget -= stop - start
+	r.ranges = append(r.ranges, fetchedRange{off: start, data: data})
+	n := copy(p, data[off-start:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,109 @@
+package unfurlist
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUnfurlist__officeDocument(t *testing.T) {
+	noise := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(noise)
+	data := testZip(t, map[string][]byte{
+		"[Content_Types].xml":   []byte(`<Types/>`),
+		"word/media/image1.png": noise,
+		"docProps/core.xml": []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties"
+ xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/">
+<dc:title>Quarterly report</dc:title><dc:creator>Jane Doe</dc:creator>
+<cp:lastModifiedBy>John Doe</cp:lastModifiedBy>
+<dcterms:created>2023-01-02T09:00:00Z</dcterms:created>
+<dcterms:modified>2023-02-03T10:30:00Z</dcterms:modified>
+</cp:coreProperties>`),
+		"docProps/app.xml": []byte(`<Properties><Pages>12</Pages><TitlesOfParts/></Properties>`),
+	})
+	var served int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.wordprocessingml.document")
+		cw := &countingWriter{ResponseWriter: w, n: &served}
+		http.ServeContent(cw, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	w := httptest.NewRecorder()
+	New().ServeHTTP(w, httptest.NewRequest("GET", "/?content="+srv.URL+"/files/report.docx", nil))
+	var res []unfurlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+		t.Fatalf("unexpected response: %q", w.Body.String())
+	}
+	r := res[0]
+	if r.Type != "document" || r.Title != "Quarterly report" || r.Author != "Jane Doe" ||
+		r.Created != "2023-01-02T09:00:00Z" || r.Modified != "2023-02-03T10:30:00Z" ||
+		r.PageCount != 12 || r.FileSize != int64(len(data)) {
+		t.Fatalf("unexpected result: %q", w.Body.String())
+	}
+	if n := atomic.LoadInt64(&served); n > officeBudget {
+		t.Errorf("%d bytes of %d were read", n, len(data))
+	}
+}
+
+func TestReadOfficeMeta__openDocument(t *testing.T) {
+	data := testZip(t, map[string][]byte{
+		"mimetype": []byte("application/vnd.oasis.opendocument.presentation"),
+		"meta.xml": []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+ xmlns:meta="urn:oasis:names:tc:opendocument:xmlns:meta:1.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<office:meta><meta:initial-creator>Jane Doe</meta:initial-creator>
+<meta:creation-date>2022-05-01T12:00:00.123</meta:creation-date>
+<dc:date>2022-06-01T08:00:00</dc:date><dc:title>Roadmap</dc:title>
+<meta:document-statistic meta:page-count="7" meta:object-count="3"/>
+</office:meta></office:document-meta>`),
+	})
+	meta, err := readOfficeMeta(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := officeMeta{title: "Roadmap", author: "Jane Doe", pages: 7,
+		created:  time.Date(2022, 5, 1, 12, 0, 0, 123e6, time.UTC),
+		modified: time.Date(2022, 6, 1, 8, 0, 0, 0, time.UTC)}
+	if *meta != want {
+		t.Fatalf("got %+v, want %+v", *meta, want)
+	}
+}
+
+func testZip(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"mimetype", "[Content_Types].xml", "docProps/core.xml",
+		"word/media/image1.png", "docProps/app.xml", "meta.xml"} {
+		data, ok := files[name]
+		if !ok {
+			continue
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
@@ -33,18 +33,25 @@ func openGraphParseHTML(chunk *pageChunk) *unfurlResult {
 		return nil
 	}
 	res := &unfurlResult{
-		Type:        og.Type,
-		Title:       og.Title,
-		Description: og.Description,
-		SiteName:    og.SiteName,
+		Type:         og.Type,
+		Title:        og.Title,
+		Description:  og.Description,
+		SiteName:     og.SiteName,
+		CanonicalURL: og.URL,
 	}
 	if len(og.Images) > 0 {
 		res.Image = og.Images[0].URL
 	}
-	if chunk.url.Host == "twitter.com" &&
-		strings.Contains(chunk.url.Path, "/status/") &&
-		!bytes.Contains(chunk.data, []byte(`property="og:image:user_generated" content="true"`)) {
+	if twitterStatusWithoutMedia(chunk) {
 		res.Image = ""
 	}
 	return res
 }
+
+// twitterStatusWithoutMedia reports whether chunk is a twitter status page
+// without user-provided media; og:image of such pages is the user avatar.
+func twitterStatusWithoutMedia(chunk *pageChunk) bool {
+	return chunk.url.Host == "twitter.com" &&
+		strings.Contains(chunk.url.Path, "/status/") &&
+		!bytes.Contains(chunk.data, []byte(`property="og:image:user_generated" content="true"`))
+}
@@ -0,0 +1,400 @@
+package unfurlist
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// pdfTailSize is the number of bytes requested from the end of pdf file to
+// get its trailer, which references document information dictionary
+const pdfTailSize = 64 * 1024
+
+// pdfMeta holds pdf document metadata
+type pdfMeta struct {
+	title   string
+	author  string
+	subject string
+	created time.Time
+	pages   int
+}
+
+// merge fills empty fields of m from m2
+func (m *pdfMeta) merge(m2 *pdfMeta) {
+	if m.title == "" {
+		m.title = m2.title
+	}
+	if m.author == "" {
+		m.author = m2.author
+	}
+	if m.subject == "" {
+		m.subject = m2.subject
+	}
+	if m.created.IsZero() {
+		m.created = m2.created
+	}
+	if m.pages == 0 {
+		m.pages = m2.pages
+	}
+}
+
+func isPDF(chunk *pageChunk) bool {
+	return bytes.HasPrefix(chunk.data, []byte("%PDF-"))
+}
+
+// applyPDF fills result with metadata of pdf document. Document information
+// dictionary and page count are looked up in the first chunk of the file, and
+// if they're not there, in the tail of the file requested with Range request.
+func (h *unfurlHandler) applyPDF(ctx context.Context, chunk *pageChunk, result *unfurlResult) {
+	result.Type = "document"
+	meta := parsePDF(chunk.data)
+	if meta.title == "" || meta.pages == 0 {
+		if tail, err := h.fetchTail(ctx, chunk.url.String(), pdfTailSize); err != nil {
+			h.Log.Printf("pdf %q tail fetch: %v", chunk.url, err)
+		} else {
+			// tail holds the trailer, but objects it references may
+			// be in the beginning of the file
+			meta.merge(parsePDF(append(append([]byte{}, chunk.data...), tail...)))
+		}
+	}
+	if result.Title == "" {
+		result.Title = meta.title
+	}
+	if result.Title == "" {
+		result.Title = pathName(chunk.url)
+	}
+	if result.Description == "" {
+		result.Description = meta.subject
+	}
+	result.Author = meta.author
+	if !meta.created.IsZero() {
+		result.Created = meta.created.Format(time.RFC3339)
+	}
+	result.PageCount = meta.pages
+}
+
+// fetchTail fetches up to n last bytes of the resource with Range request. It
+// returns error if server doesn't support range requests.
+func (h *unfurlHandler) fetchTail(ctx context.Context, URL string, n int64) ([]byte, error) {
+	return h.fetchRange(ctx, URL, fmt.Sprintf("bytes=-%d", n), n)
+}
+
+// fetchRange fetches up to limit bytes of the resource range described by
+// Range header value spec. It returns error if server doesn't support range
+// requests.
+func (h *unfurlHandler) fetchRange(ctx context.Context, URL, spec string, limit int64) ([]byte, error) {
+	client := h.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := h.newRequest(ctx, http.MethodGet, URL)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", spec)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("range request not supported: %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+var (
+	rePDFInfoRef   = regexp.MustCompile(`/Info\s+(\d+)\s+(\d+)\s+R`)
+	rePDFPages     = regexp.MustCompile(`/Type\s*/Pages\b[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages\b`)
+	rePDFLinearN   = regexp.MustCompile(`/Linearized\s[^>]*?/N\s+(\d+)`)
+	rePDFInfoGuess = regexp.MustCompile(`/(?:Producer|CreationDate)\s*[(<]`)
+)
+
+// parsePDF extracts document metadata from (possibly partial) pdf file data.
+// Objects inside compressed object streams cannot be read.
+func parsePDF(data []byte) *pdfMeta {
+	meta := new(pdfMeta)
+	var info []byte
+	// the last trailer is the most recent one
+	if refs := rePDFInfoRef.FindAllSubmatch(data, -1); len(refs) > 0 {
+		ref := refs[len(refs)-1]
+		info = pdfObject(data, string(ref[1]), string(ref[2]))
+	}
+	if info == nil {
+		if loc := rePDFInfoGuess.FindIndex(data); loc != nil {
+			if start := bytes.LastIndex(data[:loc[0]], []byte("<<")); start >= 0 {
+				info = data[start:]
+				if end := bytes.Index(info, []byte("endobj")); end >= 0 {
+					info = info[:end]
+				}
+			}
+		}
+	}
+	if info != nil {
+		meta.title = pdfDictString(info, "Title")
+		meta.author = pdfDictString(info, "Author")
+		meta.subject = pdfDictString(info, "Subject")
+		meta.created = parsePDFDate(pdfDictString(info, "CreationDate"))
+	}
+	meta.merge(parseXMP(data))
+	if m := rePDFLinearN.FindSubmatch(data); m != nil {
+		meta.pages, _ = strconv.Atoi(string(m[1]))
+	}
+	if meta.pages == 0 {
+		// page tree root has the largest count
+		for _, m := range rePDFPages.FindAllSubmatch(data, -1) {
+			s := m[1]
+			if len(s) == 0 {
+				s = m[2]
+			}
+			if n, _ := strconv.Atoi(string(s)); n > meta.pages {
+				meta.pages = n
+			}
+		}
+	}
+	return meta
+}
+
+// pdfObject returns body of indirect object with given number and generation
+func pdfObject(data []byte, num, gen string) []byte {
+	re := regexp.MustCompile(`(?:^|[^0-9])` + num + `\s+` + gen + `\s+obj\b`)
+	locs := re.FindAllIndex(data, -1)
+	if len(locs) == 0 {
+		return nil
+	}
+	obj := data[locs[len(locs)-1][1]:]
+	if i := bytes.Index(obj, []byte("endobj")); i >= 0 {
+		obj = obj[:i]
+	}
+	return obj
+}
+
+// pdfDictString returns string value of given key in pdf dictionary
+func pdfDictString(dict []byte, key string) string {
+	re := regexp.MustCompile(`/` + key + `\s*([(<])`)
+	loc := re.FindSubmatchIndex(dict)
+	if loc == nil {
+		return ""
+	}
+	var raw []byte
+	if dict[loc[2]] == '(' {
+		raw = pdfLiteralString(dict[loc[2]:])
+	} else {
+		raw = pdfHexString(dict[loc[2]:])
+	}
+	return strings.TrimSpace(pdfTextString(raw))
+}
+
+// pdfLiteralString decodes literal string starting with "(" at the beginning
+// of b
+func pdfLiteralString(b []byte) []byte {
+	var out []byte
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+		case '\\':
+			i++
+			if i == len(b) {
+				return out
+			}
+			switch c = b[i]; c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n': // line continuation
+				if c == '\r' && i+1 < len(b) && b[i+1] == '\n' {
+					i++
+				}
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := 0
+					for j := 0; j < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7'; j++ {
+						v = v*8 + int(b[i]-'0')
+						i++
+					}
+					i--
+					c = byte(v)
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// pdfHexString decodes hex string starting with "<" at the beginning of b
+func pdfHexString(b []byte) []byte {
+	var out []byte
+	var digits []byte
+	for _, c := range b[1:] {
+		if c == '>' {
+			break
+		}
+		if _, err := strconv.ParseUint(string(c), 16, 8); err == nil {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	for i := 0; i < len(digits); i += 2 {
+		v, _ := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		out = append(out, byte(v))
+	}
+	return out
+}
+
+// pdfTextString decodes pdf text string, which is either UTF-16BE with byte
+// order mark or PDFDocEncoding (treated as Latin-1)
+func pdfTextString(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+		u := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(u))
+	}
+	return latin1(b)
+}
+
+// parsePDFDate parses pdf date like "D:20230115093000+01'00'"; missing
+// trailing components default to their minimal values
+func parsePDFDate(s string) time.Time {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	digits := s
+	for i, c := range s {
+		if c < '0' || c > '9' {
+			digits = s[:i]
+			break
+		}
+	}
+	if len(digits) < 4 {
+		return time.Time{}
+	}
+	const layout = "20060102150405"
+	if len(digits) > len(layout) {
+		return time.Time{}
+	}
+	full := digits + "0101000000"[len(digits)-4:]
+	loc := time.UTC
+	if rest := strings.Replace(s[len(digits):], "'", "", -1); len(rest) >= 3 && (rest[0] == '+' || rest[0] == '-') {
+		hh, _ := strconv.Atoi(rest[1:3])
+		mm := 0
+		if len(rest) >= 5 {
+			mm, _ = strconv.Atoi(rest[3:5])
+		}
+		offset := hh*3600 + mm*60
+		if rest[0] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone("", offset)
+	}
+	t, err := time.ParseInLocation(layout, full, loc)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// parseXMP extracts metadata from uncompressed XMP packet found in data
+func parseXMP(data []byte) *pdfMeta {
+	meta := new(pdfMeta)
+	start := bytes.Index(data, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return meta
+	}
+	end := bytes.Index(data[start:], []byte("</x:xmpmeta>"))
+	if end < 0 {
+		return meta
+	}
+	d := xml.NewDecoder(bytes.NewReader(data[start : start+end+len("</x:xmpmeta>")]))
+	d.Strict = false
+	var stack []string // local names of open elements
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			// properties can also be specified as attributes of
+			// rdf:Description
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "CreateDate" && meta.created.IsZero() {
+					meta.created = parseXMPDate(attr.Value)
+				}
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" || len(stack) == 0 {
+				continue
+			}
+			prop := stack[len(stack)-1]
+			if prop == "li" && len(stack) >= 3 {
+				prop = stack[len(stack)-3] // dc:title/rdf:Alt/rdf:li
+			}
+			switch prop {
+			case "title":
+				if meta.title == "" {
+					meta.title = text
+				}
+			case "creator":
+				if meta.author == "" {
+					meta.author = text
+				}
+			case "description":
+				if meta.subject == "" {
+					meta.subject = text
+				}
+			case "CreateDate":
+				if meta.created.IsZero() {
+					meta.created = parseXMPDate(text)
+				}
+			}
+		}
+	}
+	return meta
+}
+
+// parseXMPDate parses date in one of ISO 8601 forms used by XMP
+func parseXMPDate(s string) time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04Z07:00", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
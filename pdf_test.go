@@ -0,0 +1,101 @@
+package unfurlist
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPDF = `%PDF-1.4
+1 0 obj
+<< /Type /Catalog /Pages 2 0 R >>
+endobj
+2 0 obj
+<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 >>
+endobj
+3 0 obj
+<< /Type /Page /Parent 2 0 R >>
+endobj
+7 0 obj
+<< /Title (Annual \(draft\) report) /Author <FEFF004A006F00EB006C> /Subject (Numbers)
+/CreationDate (D:20230115093000+01'00') /Producer (test) >>
+endobj
+trailer
+<< /Size 8 /Root 1 0 R /Info 7 0 R >>
+%%EOF
+`
+
+func TestParsePDF(t *testing.T) {
+	meta := parsePDF([]byte(testPDF))
+	want := pdfMeta{
+		title:   "Annual (draft) report",
+		author:  "Joël",
+		subject: "Numbers",
+		created: time.Date(2023, 1, 15, 8, 30, 0, 0, time.UTC),
+		pages:   3,
+	}
+	if meta.title != want.title || meta.author != want.author || meta.subject != want.subject ||
+		!meta.created.Equal(want.created) || meta.pages != want.pages {
+		t.Fatalf("got %+v, want %+v", *meta, want)
+	}
+}
+
+func TestParseXMP(t *testing.T) {
+	const data = `%PDF-1.7 stream
+<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+ xmp:CreateDate="2021-06-01T10:00:00Z">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">XMP title</rdf:li></rdf:Alt></dc:title>
+<dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+</rdf:Description></rdf:RDF></x:xmpmeta>
+endstream`
+	meta := parseXMP([]byte(data))
+	if meta.title != "XMP title" || meta.author != "Jane Doe" ||
+		!meta.created.Equal(time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected result: %+v", *meta)
+	}
+}
+
+func TestParsePDFDate(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want time.Time
+	}{
+		{"D:2023", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"D:20230115093000Z", time.Date(2023, 1, 15, 9, 30, 0, 0, time.UTC)},
+		{"D:20230115093000-05'30'", time.Date(2023, 1, 15, 15, 0, 0, 0, time.UTC)},
+		{"garbage", time.Time{}},
+	}
+	for _, tc := range testCases {
+		if got := parsePDFDate(tc.in); !got.Equal(tc.want) {
+			t.Errorf("parsePDFDate(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestApplyPDF__tail(t *testing.T) {
+	// large document with metadata only at its end
+	data := strings.Replace(testPDF, "3 0 obj", strings.Repeat(" ", 2*defaultMaxBodyChunkSize)+"3 0 obj", 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(data))
+	}))
+	defer srv.Close()
+	h := newHandler(WithHTTPClient(srv.Client()))
+	u, _ := url.Parse(srv.URL + "/files/report.pdf")
+	chunk := &pageChunk{data: []byte(data[:defaultMaxBodyChunkSize]), url: u}
+	if !isPDF(chunk) || !bytes.Contains(chunk.data, []byte("/Count 3")) {
+		t.Fatal("unexpected test data")
+	}
+	result := new(unfurlResult)
+	h.applyPDF(context.Background(), chunk, result)
+	if result.Type != "document" || result.Title != "Annual (draft) report" ||
+		result.Author != "Joël" || result.Created != "2023-01-15T09:30:00+01:00" || result.PageCount != 3 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
@@ -0,0 +1,192 @@
+package unfurlist
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// metaRefresh looks for <meta http-equiv="refresh" content="N;url=..."> in
+// html document head and returns refresh target (possibly relative url) and
+// delay in seconds.
+func metaRefresh(htmlBody []byte, ct string) (target string, delay int, ok bool) {
+	bodyReader, err := charset.NewReader(bytes.NewReader(htmlBody), ct)
+	if err != nil {
+		return "", 0, false
+	}
+	z := html.NewTokenizer(bodyReader)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return "", 0, false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Body:
+				return "", 0, false
+			case atom.Meta:
+				var isRefresh bool
+				var content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "http-equiv":
+						isRefresh = strings.EqualFold(strings.TrimSpace(string(v)), "refresh")
+					case "content":
+						content = string(v)
+					}
+				}
+				if !isRefresh {
+					continue
+				}
+				if target, delay, ok := parseRefresh(content); ok {
+					return target, delay, true
+				}
+			}
+		}
+	}
+}
+
+// parseRefresh parses content attribute of meta refresh tag, which has
+// "5; url=http://example.com/" form, possibly with quoted url, or url given
+// without "url=" prefix. Refresh without url is reported as not ok.
+func parseRefresh(content string) (target string, delay int, ok bool) {
+	content = strings.TrimSpace(content)
+	i := strings.IndexAny(content, ";,")
+	if i < 0 {
+		return "", 0, false
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(content[:i]), 64)
+	if err != nil || n < 0 {
+		return "", 0, false
+	}
+	s := strings.TrimSpace(content[i+1:])
+	if len(s) > 3 && strings.EqualFold(s[:3], "url") {
+		if rest := strings.TrimSpace(s[3:]); strings.HasPrefix(rest, "=") {
+			s = strings.TrimSpace(rest[1:])
+		}
+	}
+	if len(s) > 0 && (s[0] == '\'' || s[0] == '"') {
+		if j := strings.IndexByte(s[1:], s[0]); j >= 0 {
+			s = s[1 : j+1]
+		} else {
+			s = s[1:]
+		}
+	}
+	if s == "" {
+		return "", 0, false
+	}
+	return s, int(n), true
+}
+
+// maxRefreshDelay is the max delay of meta refresh redirect for page to be
+// considered an interstitial one
+const maxRefreshDelay = 10
+
+// interstitialTarget returns url fetched html page redirects to if it's an
+// interstitial one: either it has meta refresh with a short delay, or it's a
+// stub page with almost no content which redirects with javascript or meta
+// refresh and points to its canonical url within the same registrable domain.
+func interstitialTarget(chunk *pageChunk) (string, bool) {
+	if !strings.HasPrefix(http.DetectContentType(chunk.data), "text/html") {
+		return "", false
+	}
+	if target, delay, ok := metaRefresh(chunk.data, chunk.ct); ok && delay <= maxRefreshDelay {
+		return target, true
+	}
+	if canonical, ok := stubPageCanonical(chunk.data, chunk.ct); ok {
+		return trustedCanonical(chunk.url, canonical)
+	}
+	return "", false
+}
+
+// maxStubText is the max length of visible text of the stub page
+const maxStubText = 200
+
+// jsRedirectRe matches javascript code changing page location
+var jsRedirectRe = regexp.MustCompile(`\blocation(?:\.href)?\s*=[^=]|\blocation\.(?:replace|assign)\s*\(`)
+
+// stubPageCanonical returns canonical url of html page if page looks like a
+// stub: it has no Open Graph metadata, only a tiny amount of visible text,
+// and it redirects elsewhere with javascript or meta refresh.
+func stubPageCanonical(htmlBody []byte, ct string) (string, bool) {
+	bodyReader, err := charset.NewReader(bytes.NewReader(htmlBody), ct)
+	if err != nil {
+		return "", false
+	}
+	var canonical string
+	var textLen, skipDepth int
+	var redirects bool
+	z := html.NewTokenizer(bodyReader)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() != io.EOF { // page may be truncated
+				return "", false
+			}
+			return canonical, canonical != "" && redirects
+		case html.TextToken:
+			if skipDepth == 0 {
+				if textLen += len(bytes.TrimSpace(z.Text())); textLen > maxStubText {
+					return "", false
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Script, atom.Style, atom.Title, atom.Noscript:
+				if skipDepth > 0 {
+					skipDepth--
+				}
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Script:
+				switch z.Next() {
+				case html.TextToken:
+					redirects = redirects || jsRedirectRe.Match(z.Text())
+					skipDepth++
+				case html.ErrorToken:
+					return "", false
+				}
+			case atom.Style, atom.Title, atom.Noscript:
+				skipDepth++
+			case atom.Meta:
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch {
+					case string(k) == "property" && bytes.HasPrefix(v, []byte("og:")):
+						return "", false
+					case string(k) == "http-equiv" && strings.EqualFold(strings.TrimSpace(string(v)), "refresh"):
+						redirects = true
+					}
+				}
+			case atom.Link:
+				var rel, href string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "rel":
+						rel = string(v)
+					case "href":
+						href = string(v)
+					}
+				}
+				if hasRel(rel, "canonical") && canonical == "" {
+					canonical = href
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package unfurlist
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseRefresh(t *testing.T) {
+	testCases := []struct {
+		content string
+		target  string
+		delay   int
+		ok      bool
+	}{
+		{"0;url=https://example.com/", "https://example.com/", 0, true},
+		{"5; URL='/next page'", "/next page", 5, true},
+		{`3, url = "https://example.com/?a=b"`, "https://example.com/?a=b", 3, true},
+		{"0; https://example.com/", "https://example.com/", 0, true},
+		{"30", "", 0, false},
+		{"soon; url=/x", "", 0, false},
+	}
+	for _, tc := range testCases {
+		target, delay, ok := parseRefresh(tc.content)
+		if target != tc.target || delay != tc.delay || ok != tc.ok {
+			t.Errorf("%q: got %q, %d, %v; want %q, %d, %v", tc.content,
+				target, delay, ok, tc.target, tc.delay, tc.ok)
+		}
+	}
+}
+
+func TestInterstitialTarget__stub(t *testing.T) {
+	u, _ := url.Parse("https://example.com/article?id=1")
+	testCases := []struct {
+		page   string
+		target string
+	}{
+		{`<html><head><title>Article</title><link rel="canonical" href="/article"></head>
+		<body>Short text</body></html>`, ""},
+		{`<html><head><link rel="canonical" href="/article"></head>
+		<body><script>location.href = "/article"</script></body></html>`, "https://example.com/article"},
+		{`<html><head><link rel="canonical" href="https://www.example.com/article">
+		<meta http-equiv="refresh" content="3"></head></html>`, "https://www.example.com/article"},
+		{`<html><head><link rel="canonical" href="https://example.org/article"></head>
+		<body><script>location.replace("https://example.org/article")</script></body></html>`, ""},
+		{`<html><head><link rel="canonical" href="/article">
+		<meta property="og:title" content="Article"></head>
+		<body><script>location.href = "/article"</script></body></html>`, ""},
+	}
+	for _, tc := range testCases {
+		target, ok := interstitialTarget(&pageChunk{data: []byte(tc.page), url: u, ct: "text/html"})
+		if target != tc.target || ok != (tc.target != "") {
+			t.Errorf("got %q, %v for page %q", target, ok, tc.page)
+		}
+	}
+}
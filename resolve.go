@@ -0,0 +1,222 @@
+package unfurlist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// Hop describes single step of the redirect chain
+type Hop struct {
+	URL     string `json:"url"`
+	Status  int    `json:"status"`            // response status code
+	Refresh bool   `json:"refresh,omitempty"` // next hop comes from <meta http-equiv="refresh">
+}
+
+// Resolve follows redirects starting from rawurl and returns all visited hops,
+// the last one being the final destination. Both HTTP redirects and html
+// <meta http-equiv="refresh"> redirects are followed. Redirects are checked
+// against client.CheckRedirect policy; if client is nil, http.DefaultClient
+// is used.
+//
+// If error is returned, hops contain all steps made before error occurred.
+func Resolve(ctx context.Context, client *http.Client, rawurl string) ([]Hop, error) {
+	r := &resolver{client: client, maxBody: defaultMaxBodyChunkSize}
+	return r.resolve(ctx, rawurl)
+}
+
+// resolver follows redirect chains, see Resolve
+type resolver struct {
+	client  *http.Client
+	headers []string          // extra headers, key-value pairs
+	maxBody int64             // max body size to read looking for meta refresh
+	blocked func(string) bool // optional, reports whether url must not be visited
+}
+
+func (r *resolver) resolve(ctx context.Context, rawurl string) ([]Hop, error) {
+	client := r.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	cl := *client
+	cl.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	var hops []Hop
+	var via []*http.Request
+	for {
+		if !validURL(u.String()) {
+			return hops, errors.New("unsupported url: " + u.String())
+		}
+		if r.blocked != nil && r.blocked(u.String()) {
+			return hops, errBlacklisted
+		}
+		req, err := r.newRequest(ctx, http.MethodHead, u)
+		if err != nil {
+			return hops, err
+		}
+		if len(via) > 0 {
+			if err := checkRedirect(client, req, via); err != nil {
+				return hops, err
+			}
+		}
+		resp, err := cl.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		// some servers don't support HEAD properly, retry with GET;
+		// html pages are also fetched to look for meta refresh
+		if err != nil || resp.StatusCode >= http.StatusBadRequest ||
+			(resp.StatusCode < http.StatusMultipleChoices && isHTMLContentType(resp.Header.Get("Content-Type"))) {
+			if req, err = r.newRequest(ctx, http.MethodGet, u); err != nil {
+				return hops, err
+			}
+			if resp, err = cl.Do(req); err != nil {
+				return hops, err
+			}
+		}
+		hop := Hop{URL: u.String(), Status: resp.StatusCode}
+		var next string
+		switch {
+		case resp.StatusCode >= http.StatusMultipleChoices && resp.StatusCode < http.StatusBadRequest:
+			next = resp.Header.Get("Location")
+		case resp.StatusCode < http.StatusMultipleChoices && req.Method == http.MethodGet &&
+			isHTMLContentType(resp.Header.Get("Content-Type")):
+			data, err := ioutil.ReadAll(io.LimitReader(resp.Body, r.maxBody))
+			if err == nil {
+				if target, _, ok := metaRefresh(data, resp.Header.Get("Content-Type")); ok {
+					next, hop.Refresh = target, true
+				}
+			}
+		}
+		resp.Body.Close()
+		hops = append(hops, hop)
+		if next == "" {
+			return hops, nil
+		}
+		nu, err := u.Parse(next)
+		if err != nil {
+			return hops, err
+		}
+		if *nu == *u {
+			return hops, nil // page refreshing itself
+		}
+		via = append(via, req)
+		u = nu
+	}
+}
+
+func (r *resolver) newRequest(ctx context.Context, method string, u *url.URL) (*http.Request, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(r.headers); i += 2 {
+		req.Header.Set(r.headers[i], r.headers[i+1])
+	}
+	return req.WithContext(ctx), nil
+}
+
+// checkRedirect applies redirect policy of the client to req; if client has
+// no policy set, it mimics default http.Client policy of stopping after 10
+// consecutive redirects.
+func checkRedirect(client *http.Client, req *http.Request, via []*http.Request) error {
+	if client != nil && client.CheckRedirect != nil {
+		return client.CheckRedirect(req, via)
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+func isHTMLContentType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	return err == nil && (mt == "text/html" || mt == "application/xhtml+xml")
+}
+
+var errBlacklisted = errors.New("url is blacklisted")
+
+// NewResolver returns http.Handler which resolves redirect chain of the url
+// provided in `url` argument, see Resolve. Handler uses the same
+// configuration functions as New, with http client, extra headers and
+// blacklisted prefixes being relevant ones.
+//
+// Handler responds with json object:
+//
+//	{
+//		"url": "https://t.co/abc",
+//		"final_url": "https://example.com/article",
+//		"hops": [
+//			{"url": "https://t.co/abc", "status": 301},
+//			{"url": "https://example.com/article", "status": 200}
+//		]
+//	}
+//
+// If chain cannot be followed to the end, object has additional `error`
+// field, with `hops` listing steps made so far.
+func NewResolver(conf ...ConfFunc) http.Handler {
+	return &resolveHandler{newHandler(conf...)}
+}
+
+type resolveHandler struct {
+	h *unfurlHandler
+}
+
+func (rh *resolveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	link := r.Form.Get("url")
+	if !validURL(link) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	h := rh.h
+	res := &resolver{
+		client:  h.HTTPClient,
+		headers: h.Headers,
+		maxBody: h.MaxBodyChunkSize,
+	}
+	if h.pmap != nil {
+		res.blocked = h.pmap.Match
+	}
+	hops, err := res.resolve(r.Context(), link)
+	if err != nil {
+		h.Log.Printf("resolve %q: %v", link, err)
+	}
+	if len(hops) == 0 {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	out := struct {
+		URL      string `json:"url"`
+		FinalURL string `json:"final_url"`
+		Hops     []Hop  `json:"hops"`
+		Error    string `json:"error,omitempty"`
+	}{
+		URL:      link,
+		FinalURL: hops[len(hops)-1].URL,
+		Hops:     hops,
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
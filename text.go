@@ -0,0 +1,199 @@
+package unfurlist
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	snippetLines    = 10      // number of lines of text file preview
+	maxSnippetLines = 50      // max number of lines selected with url fragment
+	maxSnippetSize  = 4 << 10 // max size of preview in bytes
+)
+
+// textContentTypes are MIME types of source code files not having text/
+// prefix
+var textContentTypes = map[string]bool{
+	"application/javascript": true, "application/x-javascript": true,
+	"application/typescript": true, "application/json": true,
+	"application/x-sh": true, "application/x-python": true,
+	"application/x-ruby": true, "application/x-perl": true,
+	"application/x-httpd-php": true, "application/sql": true,
+	"application/toml": true, "application/yaml": true,
+	"application/x-yaml": true,
+}
+
+// languageExtensions maps file extensions to language names; plain text files
+// map to empty string
+var languageExtensions = map[string]string{
+	".txt": "", ".text": "", ".log": "",
+	".go": "go", ".py": "python", ".rb": "ruby", ".rs": "rust",
+	".js": "javascript", ".mjs": "javascript", ".cjs": "javascript",
+	".jsx": "jsx", ".ts": "typescript", ".tsx": "tsx",
+	".java": "java", ".kt": "kotlin", ".kts": "kotlin", ".scala": "scala",
+	".swift": "swift", ".m": "objectivec", ".mm": "objectivec",
+	".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".cxx": "cpp",
+	".hpp": "cpp", ".hh": "cpp", ".cs": "csharp", ".fs": "fsharp",
+	".php": "php", ".pl": "perl", ".pm": "perl", ".lua": "lua", ".r": "r",
+	".dart": "dart", ".ex": "elixir", ".exs": "elixir", ".erl": "erlang",
+	".hs": "haskell", ".clj": "clojure", ".ml": "ocaml", ".jl": "julia",
+	".sh": "shell", ".bash": "shell", ".zsh": "shell", ".fish": "fish",
+	".ps1": "powershell", ".bat": "batch", ".sql": "sql",
+	".html": "html", ".htm": "html", ".css": "css", ".scss": "scss",
+	".less": "less", ".xml": "xml", ".svg": "xml", ".json": "json",
+	".yaml": "yaml", ".yml": "yaml", ".toml": "toml", ".ini": "ini",
+	".md": "markdown", ".markdown": "markdown", ".rst": "rst",
+	".tex": "latex", ".proto": "protobuf", ".graphql": "graphql",
+	".vue": "vue", ".diff": "diff", ".patch": "diff", ".csv": "csv",
+	".tf": "hcl", ".cmake": "cmake", ".mk": "makefile", ".vim": "vim",
+}
+
+// languageFileNames maps names of files without meaningful extension to
+// language names
+var languageFileNames = map[string]string{
+	"Makefile": "makefile", "GNUmakefile": "makefile",
+	"Dockerfile": "dockerfile", "Containerfile": "dockerfile",
+	"CMakeLists.txt": "cmake", "Gemfile": "ruby", "Rakefile": "ruby",
+	"Vagrantfile": "ruby", "Jenkinsfile": "groovy", "BUILD": "starlark",
+	"WORKSPACE": "starlark", "go.mod": "gomod",
+}
+
+// shebangLanguages maps interpreters found in shebang lines to language names
+var shebangLanguages = map[string]string{
+	"sh": "shell", "bash": "shell", "zsh": "shell", "ksh": "shell",
+	"dash": "shell", "fish": "fish", "python": "python", "ruby": "ruby",
+	"perl": "perl", "php": "php", "node": "javascript", "deno": "typescript",
+	"lua": "lua", "Rscript": "r", "tclsh": "tcl", "awk": "awk",
+	"pwsh": "powershell",
+}
+
+// lineRangeRe matches url fragments selecting lines, like "L10" or "L10-L20"
+var lineRangeRe = regexp.MustCompile(`^L(\d+)(?:-L(\d+))?$`)
+
+// lineRange parses url fragment like "L10-L20" into numbers of the first and
+// the last line selected
+func lineRange(fragment string) (first, last int, ok bool) {
+	m := lineRangeRe.FindStringSubmatch(fragment)
+	if m == nil {
+		return 0, 0, false
+	}
+	first, _ = strconv.Atoi(m[1])
+	last = first
+	if m[2] != "" {
+		last, _ = strconv.Atoi(m[2])
+	}
+	if last < first {
+		first, last = last, first
+	}
+	return first, last, first > 0
+}
+
+// isTextFile reports whether resource is plain text or source code file
+func isTextFile(chunk *pageChunk) bool {
+	if len(chunk.data) == 0 || strings.HasPrefix(http.DetectContentType(chunk.data), "text/html") {
+		return false
+	}
+	ct, _, _ := mime.ParseMediaType(chunk.ct)
+	switch {
+	case ct == "text/html":
+		return false
+	case strings.HasPrefix(ct, "text/"), textContentTypes[ct]:
+		return true
+	case ct == "" || ct == "application/octet-stream":
+		return strings.HasPrefix(http.DetectContentType(chunk.data), "text/plain") &&
+			textLanguage(chunk.url, chunk.data) != ""
+	}
+	return false
+}
+
+// textLanguage detects language of source code file from its name or shebang
+// line; it returns empty string for plain text files
+func textLanguage(u *url.URL, data []byte) string {
+	name := path.Base(u.Path)
+	if lang, ok := languageFileNames[name]; ok {
+		return lang
+	}
+	if lang, ok := languageExtensions[strings.ToLower(path.Ext(name))]; ok {
+		return lang
+	}
+	if !bytes.HasPrefix(data, []byte("#!")) {
+		return ""
+	}
+	line := data[2:]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return ""
+	}
+	interp := path.Base(fields[0])
+	if interp == "env" {
+		interp = ""
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") && !strings.Contains(f, "=") {
+				interp = f
+				break
+			}
+		}
+	}
+	// python3.11 -> python
+	return shebangLanguages[strings.TrimRight(interp, "0123456789.")]
+}
+
+// applyText fills result with preview of text file: first lines of the file,
+// or lines selected with url fragment like "#L10-L20". Only lines found in
+// the chunk can be shown.
+func (h *unfurlHandler) applyText(chunk *pageChunk, result *unfurlResult) {
+	result.Type = "text"
+	if result.Title == "" {
+		result.Title = pathName(chunk.url)
+	}
+	result.Language = textLanguage(chunk.url, chunk.data)
+
+	data := chunk.data
+	if r, err := charset.NewReader(bytes.NewReader(data), chunk.ct); err == nil {
+		if b, err := ioutil.ReadAll(r); err == nil {
+			data = b
+		}
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	if int64(len(chunk.data)) >= h.MaxBodyChunkSize || lines[len(lines)-1] == "" {
+		// last line is either incomplete or empty
+		lines = lines[:len(lines)-1]
+	}
+	first, last, ok := lineRange(chunk.url.Fragment)
+	if !ok {
+		first, last = 1, snippetLines
+	}
+	if last-first >= maxSnippetLines {
+		last = first + maxSnippetLines - 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	if first > last {
+		return
+	}
+	snippet := strings.Join(lines[first-1:last], "\n")
+	if len(snippet) > maxSnippetSize {
+		i := maxSnippetSize
+		for i > 0 && !utf8.RuneStart(snippet[i]) {
+			i--
+		}
+		snippet = snippet[:i]
+	}
+	result.Snippet = snippet
+	result.FirstLine, result.LastLine = first, last
+}
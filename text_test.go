@@ -0,0 +1,74 @@
+package unfurlist
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTextLanguage(t *testing.T) {
+	testCases := []struct {
+		path, data, want string
+	}{
+		{"/src/main.go", "package main", "go"},
+		{"/app/Dockerfile", "FROM alpine", "dockerfile"},
+		{"/LIB.PY", "", "python"},
+		{"/bin/deploy", "#!/usr/bin/env -S python3.11 -u\nimport os", "python"},
+		{"/bin/run", "#!/bin/bash\nset -e", "shell"},
+		{"/notes.txt", "#!/bin/bash", ""},
+		{"/README", "Read me", ""},
+	}
+	for _, tc := range testCases {
+		u := &url.URL{Path: tc.path}
+		if got := textLanguage(u, []byte(tc.data)); got != tc.want {
+			t.Errorf("textLanguage(%q, %q) = %q, want %q", tc.path, tc.data, got, tc.want)
+		}
+	}
+}
+
+func TestUnfurlist__text(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 30; i++ {
+		lines = append(lines, "line "+strings.Repeat("x", i%3))
+	}
+	src := "package main\r\n\r\nfunc main() {\r\n\tprintln(\"hi\")\r\n}\r\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/blob/main.go":
+			http.Redirect(w, r, "/raw/main.go", http.StatusFound)
+		case "/raw/main.go":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(src))
+		case "/notes.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(strings.Join(lines, "\n")))
+		}
+	}))
+	defer srv.Close()
+
+	testCases := []struct {
+		url       string
+		snippet   string
+		language  string
+		firstLine int
+	}{
+		{srv.URL + "/blob/main.go#L3-L4", "func main() {\n\tprintln(\"hi\")", "go", 3},
+		{srv.URL + "/raw/main.go#L5", "}", "go", 5},
+		{srv.URL + "/notes.txt", strings.Join(lines[:snippetLines], "\n"), "", 1},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		New().ServeHTTP(w, httptest.NewRequest("GET", "/?content="+url.QueryEscape(tc.url), nil))
+		var res []unfurlResult
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+			t.Fatalf("unexpected response: %q", w.Body.String())
+		}
+		if r := res[0]; r.Type != "text" || r.Snippet != tc.snippet || r.Language != tc.language ||
+			r.FirstLine != tc.firstLine {
+			t.Errorf("unexpected result for %q: %q", tc.url, w.Body.String())
+		}
+	}
+}
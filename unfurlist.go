@@ -11,30 +11,115 @@
 //
 // Example:
 //
-//     ?content=Check+this+out+https://www.youtube.com/watch?v=dQw4w9WgXcQ
+//	?content=Check+this+out+https://www.youtube.com/watch?v=dQw4w9WgXcQ
 //
 // Will return:
 //
-//     Type: "application/json"
+//	    Type: "application/json"
 //
-// 	[
-// 		{
-// 			"title": "Rick Astley - Never Gonna Give You Up",
-// 			"url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
-// 			"url_type": "video",
-// 			"site_name": "YouTube",
-// 			"image": "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg"
-// 		}
-// 	]
+//		[
+//			{
+//				"title": "Rick Astley - Never Gonna Give You Up",
+//				"url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+//				"url_type": "video",
+//				"site_name": "YouTube",
+//				"image": "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg"
+//			}
+//		]
 //
 // If handler was configured with FetchImageSize=true in its config, each hash
 // may have additional fields `image_width` and `image_height` specifying
-// dimensions of image provided by `image` attribute.
+// dimensions of image provided by `image` attribute, `image_type` holding its
+// detected mime type and `image_size` holding its size in bytes, if known.
+// Dimensions of jpeg, png, gif, webp, avif, bmp, ico, tiff and svg images can
+// be detected. If handler has memcache configured, dimensions are cached by
+// image url for the duration set with WithImageCacheTTL.
+//
+// By default `content` is treated as a plain text. Pass `format=markdown` or
+// `format=html` to extract links from markdown or html markup: urls inside code
+// blocks are skipped then, and each hash gets additional `link_text` field
+// holding text of the link, if any.
+//
+// Each hash also has `offset` and `length` fields describing position of the
+// url in `content` as byte offsets; `rune_offset` and `rune_length` fields
+// hold the same position measured in unicode code points. Position covers
+// the whole link markup for markdown and html links. If the same url occurs
+// multiple times, position of its first occurrence is reported, and
+// `occurrences` field lists positions of all its occurrences, as hashes with
+// the same four fields.
+//
+// Scheme-less urls like "www.example.com/page" or "example.com" are detected
+// if handler was configured with WithBareDomains(true) or if request has
+// `bare_domains=true` argument; `bare_domains=false` disables such detection
+// for a single request. Such urls are unfurled as https ones.
+//
+// Preview image is picked among og:image, twitter:image, JSON-LD images,
+// <link rel="image_src"> and large <img> tags found in the page; tracking
+// pixels, too small images and images looking like logos or sprites are
+// avoided. If handler was configured with WithImageCandidates(n), each hash may
+// have `images` field listing up to n best candidates with their dimensions,
+// if known. If handler was configured with WithImageValidation(true), images
+// which are unreachable, are not actually images or are too small are skipped.
+//
+// When url was fetched, `final_url` holds url of the resource after all
+// redirects, `canonical_url` holds canonical url declared by the page
+// (<link rel="canonical"> or og:url) if it belongs to the same site. If handler
+// was configured with WithRedirectChain(true), `redirects` lists urls visited
+// before reaching `final_url`.
+//
+// If handler was configured with WithImagePlaceholders(true), each hash may
+// have `image_blurhash` field holding BlurHash (https://blurha.sh) of the
+// image and `image_color` field holding its dominant color like "#3a6ea5";
+// `image_width` and `image_height` are reported then as well.
+//
+// Links to pdf documents have `url_type` set to "document", with `title`,
+// `description`, `author`, `created` and `page_count` fields taken from the
+// document metadata where available.
+//
+// Links to audio and video files (mp4, QuickTime, webm, matroska, mp3, ogg and
+// flac) have `url_type` set to "audio" or "video" and `media` object with
+// `duration` (in seconds), `width`, `height`, `codec`, `title` and `artist`
+// fields where available.
+//
+// Links to RSS and Atom feeds have `url_type` set to "feed", with `title`,
+// `description` and `image` of the feed and `entries` list holding `title`,
+// `url` and `date` of up to 5 latest entries (see WithFeedEntries). Pages
+// advertising a feed with <link rel="alternate"> have its url in `feed_url`
+// field.
+//
+// Links to iCalendar (.ics) files have `url_type` set to "event" and `event`
+// object with `title`, `start`, `end`, `timezone`, `all_day`, `location`,
+// `organizer` and `recurrence` (RRULE) fields of the first event in the file.
+// Start and end are reported in RFC 3339 format, or as dates for all-day
+// events. Html pages describing a single event with JSON-LD Event object have
+// `event` object as well.
+//
+// Links to plain text and source code files have `url_type` set to "text",
+// `snippet` field holding their first 10 lines and `language` field holding
+// language detected from file extension or shebang line. Url fragments like
+// "#L10-L20" select lines shown in the snippet, up to 50 lines; `first_line`
+// and `last_line` fields hold numbers of lines shown.
+//
+// Links to other binary files, like archives, installers or spreadsheets, have
+// `url_type` set to "file", `title` set to the file name, and `mime_type`,
+// `file_size` and `modified` fields taken from response headers. Urls with
+// extensions of such files are requested with HEAD request first, so large
+// files are not downloaded.
+//
+// Office Open XML (docx, xlsx, pptx) and OpenDocument (odt, ods, odp) files
+// are reported with `url_type` set to "document" and `title`, `author`,
+// `created`, `modified`, `page_count` and `slide_count` fields taken from the
+// document metadata where available. Only zip central directory and metadata
+// entries of such files are fetched with Range requests, up to 512KiB total.
+//
+// If handler was configured with WithImageProxy, `image` and `icon` urls (and
+// urls of `images` list) are replaced with signed urls of the image proxy, see
+// NewImageProxy.
 //
 // Additionally you can supply `callback` to wrap the result in a JavaScript callback (JSONP),
 // the type of this response would be "application/x-javascript"
 //
-// Security
+// # Security
 //
 // Care should be taken when running this inside internal network since it may
 // disclose internal endpoints. It is a good idea to run the service on
@@ -67,8 +152,10 @@ import (
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/html/charset"
 
@@ -86,6 +173,43 @@ type unfurlHandler struct {
 	MaxBodyChunkSize int64
 	FetchImageSize   bool
 
+	// FetchManifest enables fetching of web app manifests declared by
+	// pages to get site name, theme color and icons
+	FetchManifest bool
+
+	// ValidateImages enables checking that preview images are reachable
+	// and are actual images of reasonable size; broken images are
+	// replaced with other candidates or dropped
+	ValidateImages bool
+
+	// ImagePlaceholders enables computing BlurHash and dominant color
+	// of preview images
+	ImagePlaceholders bool
+
+	// ImageCacheTTL is how long results of image probes are cached
+	ImageCacheTTL time.Duration
+
+	// ImageCandidates is the max number of image candidates to report in
+	// result's images list, zero disables such list
+	ImageCandidates int
+
+	// IconSize is the preferred icon size in pixels used to pick the
+	// best one among icons declared by page
+	IconSize int
+
+	// FeedEntries is the max number of the latest entries reported for
+	// RSS and Atom feeds, zero disables such list
+	FeedEntries int
+
+	// ReportRedirects enables reporting of redirect chain each url went
+	// through before reaching its final destination.
+	ReportRedirects bool
+
+	// BareDomains enables detection of scheme-less urls like
+	// "www.example.com/page" or "example.com" in content; can be
+	// overridden per request with `bare_domains` argument.
+	BareDomains bool
+
 	// Headers specify key-value pairs of extra headers to add to each
 	// outgoing request made by Handler. Headers length must be even,
 	// otherwise Headers are ignored.
@@ -93,11 +217,19 @@ type unfurlHandler struct {
 
 	titleBlacklist []string
 
+	canonicalize Canonicalizer // optional
+
+	imageProxy   *imageProxy       // optional, see WithImageProxy
+	placeholders *placeholderCache // see imagePlaceholder
+	thumbs       *thumbCache       // optional, see WithThumbnailCache
+
 	pmap *prefixMap // built from BlacklistPrefix
 
-	fetchers []FetchFunc
-	mu       sync.Mutex
-	inFlight map[string]chan struct{} // in-flight urls processed
+	fetchers       []FetchFunc
+	customFetchers []Fetcher
+	fetchClient    *http.Client // HTTPClient adding Headers, passed to fetchers
+	mu             sync.Mutex
+	inFlight       map[string]chan struct{} // in-flight urls processed
 }
 
 // Result that's returned back to the client
@@ -110,10 +242,64 @@ type unfurlResult struct {
 	Image       string `json:"image,omitempty"`
 	ImageWidth  int    `json:"image_width,omitempty"`
 	ImageHeight int    `json:"image_height,omitempty"`
-	IconUrl     string `json:"icon"`
-	IconType    string `json:"icon_type"`
+	ImageType   string `json:"image_type,omitempty"`
+	ImageSize   int64  `json:"image_size,omitempty"`
+	IconUrl     string `json:"icon,omitempty"`
+	IconType    string `json:"icon_type,omitempty"`
+	IconWidth   int    `json:"icon_width,omitempty"`
+	IconHeight  int    `json:"icon_height,omitempty"`
+	ThemeColor  string `json:"theme_color,omitempty"`
+	LinkText    string `json:"link_text,omitempty"`
+
+	// document metadata
+	Author     string `json:"author,omitempty"`
+	Created    string `json:"created,omitempty"` // RFC 3339
+	PageCount  int    `json:"page_count,omitempty"`
+	SlideCount int    `json:"slide_count,omitempty"`
+
+	// file metadata
+	MIMEType string `json:"mime_type,omitempty"`
+	FileSize int64  `json:"file_size,omitempty"`
+	Modified string `json:"modified,omitempty"` // RFC 3339
+
+	Media *mediaInfo `json:"media,omitempty"` // audio and video files
+	Event *eventInfo `json:"event,omitempty"` // calendar events
+
+	// text and source code files
+	Snippet   string `json:"snippet,omitempty"`
+	Language  string `json:"language,omitempty"`
+	FirstLine int    `json:"first_line,omitempty"` // of snippet, 1-based
+	LastLine  int    `json:"last_line,omitempty"`
+
+	ImageBlurHash string      `json:"image_blurhash,omitempty"`
+	ImageColor    string      `json:"image_color,omitempty"`
+	Images        []imageInfo `json:"images,omitempty"` // see WithImageCandidates
+
+	FeedURL string      `json:"feed_url,omitempty"` // feed advertised by page
+	Entries []feedEntry `json:"entries,omitempty"`  // latest entries of feed
+
+	FinalURL     string   `json:"final_url,omitempty"`
+	CanonicalURL string   `json:"canonical_url,omitempty"`
+	Redirects    []string `json:"redirects,omitempty"`
+
+	Offset     int `json:"offset"`
+	Length     int `json:"length"`
+	RuneOffset int `json:"rune_offset"`
+	RuneLength int `json:"rune_length"`
+
+	Occurrences []linkPosition `json:"occurrences,omitempty"` // all positions of url
+
+	idx      int
+	icons    []iconCandidate // icons declared by page, see bestIcon
+	manifest string          // web app manifest url declared by page
+}
 
-	idx int
+// linkPosition is a position of url in content, see unfurlResult.Occurrences
+type linkPosition struct {
+	Offset     int `json:"offset"`
+	Length     int `json:"length"`
+	RuneOffset int `json:"rune_offset"`
+	RuneLength int `json:"rune_length"`
 }
 
 func (u *unfurlResult) Empty() bool {
@@ -154,12 +340,29 @@ func (u *unfurlResult) Merge(u2 *unfurlResult) {
 	if u.ImageHeight == 0 {
 		u.ImageHeight = u2.ImageHeight
 	}
+	if u.CanonicalURL == "" {
+		u.CanonicalURL = u2.CanonicalURL
+	}
 	if u.IconUrl == "" {
 		if u2.IconUrl != "" {
 			u.IconType = u2.IconType
 			u.IconUrl = u2.IconUrl
+			u.IconWidth = u2.IconWidth
+			u.IconHeight = u2.IconHeight
 		}
 	}
+	if u.ThemeColor == "" {
+		u.ThemeColor = u2.ThemeColor
+	}
+	if u.icons == nil {
+		u.icons = u2.icons
+	}
+	if u.manifest == "" {
+		u.manifest = u2.manifest
+	}
+	if u.FeedURL == "" {
+		u.FeedURL = u2.FeedURL
+	}
 }
 
 type unfurlResults []*unfurlResult
@@ -174,9 +377,13 @@ type ConfFunc func(*unfurlHandler) *unfurlHandler
 
 // New returns new initialized unfurl handler. If no configuration functions
 // provided, sane defaults would be used.
-func New(conf ...ConfFunc) http.Handler {
+func New(conf ...ConfFunc) http.Handler { return newHandler(conf...) }
+
+func newHandler(conf ...ConfFunc) *unfurlHandler {
 	h := &unfurlHandler{
-		inFlight: make(map[string]chan struct{}),
+		inFlight:     make(map[string]chan struct{}),
+		placeholders: newPlaceholderCache(placeholderCacheSize),
+		FeedEntries:  defaultFeedEntries,
 	}
 	for _, f := range conf {
 		h = f(h)
@@ -187,9 +394,16 @@ func New(conf ...ConfFunc) http.Handler {
 	if len(h.Headers)%2 != 0 {
 		h.Headers = nil
 	}
+	h.fetchClient = fetcherClient(h.HTTPClient, h.Headers)
 	if h.MaxBodyChunkSize == 0 {
 		h.MaxBodyChunkSize = defaultMaxBodyChunkSize
 	}
+	if h.IconSize <= 0 {
+		h.IconSize = defaultIconSize
+	}
+	if h.ImageCacheTTL <= 0 {
+		h.ImageCacheTTL = defaultImageCacheTTL
+	}
 	if h.Log == nil {
 		h.Log = log.New(ioutil.Discard, "", 0)
 	}
@@ -222,21 +436,29 @@ func (h *unfurlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	urls := parseURLsMax(content, 20)
+	bare := h.BareDomains
+	if v, err := strconv.ParseBool(r.Form.Get("bare_domains")); err == nil {
+		bare = v
+	}
+	links, ok := parseLinks(content, r.Form.Get("format"), bare, 20)
+	if !ok {
+		http.Error(w, "Unsupported format", http.StatusBadRequest)
+		return
+	}
 
 	jobResults := make(chan *unfurlResult, 1)
-	results := make(unfurlResults, 0, len(urls))
+	results := make(unfurlResults, 0, len(links))
 	ctx := r.Context()
 
-	for i, r := range urls {
+	for i, l := range links {
 		go func(ctx context.Context, i int, link string, jobResults chan *unfurlResult) {
 			select {
 			case jobResults <- h.processURL(ctx, i, link):
 			case <-ctx.Done():
 			}
-		}(ctx, i, r, jobResults)
+		}(ctx, i, l.URL, jobResults)
 	}
-	for i := 0; i < len(urls); i++ {
+	for i := 0; i < len(links); i++ {
 		select {
 		case <-ctx.Done():
 			return
@@ -248,6 +470,18 @@ func (h *unfurlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	sort.Sort(results)
 	for _, r := range results {
 		r.normalize()
+		m := links[r.idx]
+		r.LinkText = m.Text
+		r.Offset, r.Length = m.Offset, m.Length
+		r.RuneOffset, r.RuneLength = m.RuneOffset, m.RuneLength
+		r.Occurrences = make([]linkPosition, len(m.all))
+		for i, o := range m.all {
+			r.Occurrences[i] = linkPosition{Offset: o.Offset, Length: o.Length,
+				RuneOffset: o.RuneOffset, RuneLength: o.RuneLength}
+		}
+		if h.imageProxy != nil {
+			h.imageProxy.rewrite(r)
+		}
 	}
 
 	if callback != "" {
@@ -266,72 +500,119 @@ func (h *unfurlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(results)
 }
 
-// Processes the URL by first looking in cache, then trying oEmbed, OpenGraph
-// If no match is found the result will be an object that just contains the URL
-func (h *unfurlHandler) processURL(ctx context.Context, i int, link string) *unfurlResult {
-	result := &unfurlResult{idx: i, URL: link}
+// acquire ensures there are no two in-flight outgoing requests for the same
+// key: it waits until other goroutine processing the same key releases it and
+// marks key as in-flight. Returned release function must be called once key
+// is processed. If ctx is canceled while waiting, acquire returns false.
+func (h *unfurlHandler) acquire(ctx context.Context, key string) (release func(), ok bool) {
 	waitLogged := false
 	for {
-		// spinlock-like loop to ensure we don't have two in-flight
-		// outgoing requests for the same link
+		// spinlock-like loop
 		h.mu.Lock()
-		if ch, ok := h.inFlight[link]; ok {
-			h.mu.Unlock()
-			if !waitLogged {
-				h.Log.Printf("Wait for in-flight request to complete %q", link)
-				waitLogged = true
-			}
-			select {
-			case <-ch: // block until another goroutine processes the same url
-			case <-ctx.Done():
-				return result
-			}
-		} else {
+		ch, ok := h.inFlight[key]
+		if !ok {
 			ch = make(chan struct{})
-			h.inFlight[link] = ch
+			h.inFlight[key] = ch
 			h.mu.Unlock()
-			defer func() {
+			return func() {
 				h.mu.Lock()
-				delete(h.inFlight, link)
+				delete(h.inFlight, key)
 				h.mu.Unlock()
 				close(ch)
-			}()
-			break
+			}, true
+		}
+		h.mu.Unlock()
+		if !waitLogged {
+			h.Log.Printf("Wait for in-flight request to complete %q", key)
+			waitLogged = true
+		}
+		select {
+		case <-ch: // block until another goroutine processes the same key
+		case <-ctx.Done():
+			return nil, false
 		}
 	}
+}
 
-	if h.pmap != nil && h.pmap.Match(link) { // blacklisted
+// Processes the URL by first looking in cache, then trying oEmbed, OpenGraph
+// If no match is found the result will be an object that just contains the URL
+func (h *unfurlHandler) processURL(ctx context.Context, i int, link string) *unfurlResult {
+	result := &unfurlResult{idx: i, URL: link}
+	key := link // canonical url used for dedupe, caching and fetching
+	if h.canonicalize != nil {
+		key = h.canonicalize(link)
+	}
+	release, ok := h.acquire(ctx, key)
+	if !ok {
+		return result
+	}
+	defer release()
+
+	if h.pmap != nil && (h.pmap.Match(link) || h.pmap.Match(key)) { // blacklisted
 		h.Log.Printf("Blacklisted %q", link)
 		return result
 	}
 
-	if mc := h.Cache; mc != nil {
-		if it, err := mc.Get(mcKey(link)); err == nil {
-			var cached unfurlResult
-			if err = json.Unmarshal(it.Value, &cached); err == nil {
-				h.Log.Printf("Cache hit for %q", link)
-				cached.idx = i
-				return &cached
-			}
+	if cached, _, ok := h.cacheGet(key); ok {
+		h.Log.Printf("Cache hit for %q", key)
+		cached.idx = i
+		cached.URL = link
+		return cached
+	}
+	var chunk *pageChunk
+	var err error
+	var finalKey string
+	var fetcherMatch bool // image provided by fetcher is authoritative
+	if u, err := url.Parse(key); err == nil {
+		if meta, ok := h.fetch(ctx, PreFetch, &FetchRequest{URL: u, Client: h.fetchClient}); ok {
+			// page is not fetched, fetcher metadata is all there is
+			meta.replace(result)
+			chunk = &pageChunk{url: u, header: make(http.Header)}
+			finalKey, fetcherMatch = key, true
+			goto hasMatch
 		}
 	}
-	chunk, err := h.fetchData(ctx, result.URL)
+	chunk, err = h.fetchData(ctx, key)
 	if err != nil {
 		return result
 	}
-	for _, f := range h.fetchers {
-		meta, ok := f(chunk.url)
-		if !ok || !meta.Valid() {
-			continue
-		}
-		result.Title = meta.Title
-		result.Type = meta.Type
-		result.Description = meta.Description
-		result.Image = meta.Image
-		result.ImageWidth = meta.ImageWidth
-		result.ImageHeight = meta.ImageHeight
-		result.IconUrl = meta.IconUrl
-		result.IconType = meta.IconType
+	if u, err := url.Parse(key); err == nil && chunk.url.Fragment == "" {
+		if _, _, ok := lineRange(u.Fragment); ok {
+			// fragment is lost on redirects, while line range
+			// selects snippet of text file
+			final := *chunk.url
+			final.Fragment = u.Fragment
+			chunk.url = &final
+		}
+	}
+	result.FinalURL = chunk.url.String()
+	if h.ReportRedirects {
+		result.Redirects = chunk.redirects
+	}
+	// different urls may lead to the same page, reuse cached result if
+	// there's one
+	finalKey = result.FinalURL
+	if h.canonicalize != nil {
+		finalKey = h.canonicalize(finalKey)
+	}
+	if finalKey != key {
+		if cached, primary, ok := h.cacheGet(finalKey); ok {
+			h.Log.Printf("Cache hit for %q via %q", key, finalKey)
+			cached.idx = i
+			cached.URL = link
+			cached.FinalURL, cached.Redirects = result.FinalURL, result.Redirects
+			h.cacheAlias(primary, cached, key)
+			return cached
+		}
+	}
+	if meta, ok := h.fetch(ctx, Authoritative, &FetchRequest{
+		URL:         chunk.url,
+		Client:      h.fetchClient,
+		Page:        chunk.data,
+		ContentType: chunk.ct,
+	}); ok {
+		meta.replace(result)
+		fetcherMatch = true
 		goto hasMatch
 	}
 
@@ -355,9 +636,52 @@ hasMatch:
 		}
 	}
 
-	if absURL, err := absoluteImageURL(result.URL, result.IconUrl); err == nil {
+	if isPDF(chunk) {
+		h.applyPDF(ctx, chunk, result)
+	} else if format := mediaFormat(chunk.data); format != "" {
+		h.applyMedia(ctx, chunk, result, format)
+	} else if feed := parseFeed(chunk, h.FeedEntries); feed != nil {
+		applyFeed(chunk, result, feed)
+	} else if isCalendar(chunk) {
+		if ev := parseICalendar(chunk.data); ev != nil {
+			applyEvent(chunk, result, ev)
+		}
+	} else if isTextFile(chunk) {
+		h.applyText(chunk, result)
+	} else if mimeType, ok := fileType(chunk); ok {
+		applyFile(chunk, result, mimeType)
+		if isOfficeDocument(chunk, mimeType) {
+			h.applyOffice(ctx, chunk, result)
+		}
+	} else if ev := jsonLDEvent(chunk); ev != nil {
+		result.Event = ev
+	}
+	if h.FetchManifest {
+		h.applyManifest(ctx, chunk.url, result)
+	}
+	// guessing of favicon and image is only done for html pages which
+	// fetchers don't know about
+	htmlPage := !fetcherMatch && strings.HasPrefix(http.DetectContentType(chunk.data), "text/html")
+	if h.enrich(ctx, chunk, result) {
+		fetcherMatch = true
+	}
+	if result.IconUrl == "" {
+		if icon, size, ok := bestIcon(result.icons, h.IconSize); ok {
+			result.IconUrl, result.IconType = icon.url, icon.typ
+			result.IconWidth, result.IconHeight = size.width, size.height
+		} else if htmlPage {
+			if u, ok := h.faviconFallback(ctx, chunk.url); ok {
+				result.IconUrl = u
+			}
+		}
+	}
+	if absURL, err := absoluteImageURL(chunk.url.String(), result.IconUrl); err == nil {
 		result.IconUrl = absURL
 	}
+	var imageValidated bool
+	if htmlPage && !fetcherMatch && h.needImageCandidates(result) && !twitterStatusWithoutMedia(chunk) {
+		imageValidated = h.pickImage(ctx, chunk, result)
+	}
 	switch absURL, err := absoluteImageURL(result.URL, result.Image); err {
 	case errEmptyImageURL:
 	case nil:
@@ -367,11 +691,30 @@ hasMatch:
 		default:
 			result.Image = ""
 		}
+		if result.Image != "" && h.ValidateImages && !imageValidated {
+			if meta, ok := h.validImage(ctx, result.Image); ok {
+				result.ImageWidth, result.ImageHeight = meta.width, meta.height
+				result.ImageType, result.ImageSize = meta.mime, meta.size
+			} else {
+				result.Image, result.ImageWidth, result.ImageHeight = "", 0, 0
+			}
+		}
 		if result.Image != "" && h.FetchImageSize && (result.ImageWidth == 0 || result.ImageHeight == 0) {
-			if width, height, err := imageDimensions(ctx, h.HTTPClient, result.Image); err != nil {
+			if meta, err := h.probeImage(ctx, result.Image); err != nil {
 				h.Log.Printf("dimensions detect for image %q: %v", result.Image, err)
 			} else {
-				result.ImageWidth, result.ImageHeight = width, height
+				result.ImageWidth, result.ImageHeight = meta.width, meta.height
+				result.ImageType, result.ImageSize = meta.mime, meta.size
+			}
+		}
+		if result.Image != "" && h.ImagePlaceholders {
+			if meta, err := h.imagePlaceholder(ctx, result.Image); err != nil {
+				h.Log.Printf("placeholder for image %q: %v", result.Image, err)
+			} else {
+				result.ImageBlurHash, result.ImageColor = meta.blurHash, meta.color
+				if result.ImageWidth == 0 || result.ImageHeight == 0 {
+					result.ImageWidth, result.ImageHeight = meta.width, meta.height
+				}
 			}
 		}
 	default:
@@ -379,20 +722,33 @@ hasMatch:
 		result.Image, result.ImageWidth, result.ImageHeight = "", 0, 0
 	}
 
-	if mc := h.Cache; mc != nil && !result.Empty() {
-		if cdata, err := json.Marshal(result); err == nil {
-			h.Log.Printf("Cache update for %q", link)
-			mc.Set(&memcache.Item{Key: mcKey(link), Value: cdata})
+	if canonical, ok := trustedCanonical(chunk.url, result.CanonicalURL); ok {
+		result.CanonicalURL = canonical
+	} else {
+		result.CanonicalURL = ""
+	}
+	if !result.Empty() {
+		// cache result under canonical url, so different urls leading
+		// to the same page share single cache entry
+		primary := key
+		if result.CanonicalURL != "" {
+			primary = result.CanonicalURL
+			if h.canonicalize != nil {
+				primary = h.canonicalize(primary)
+			}
 		}
+		h.cacheSet(result, primary, key, finalKey)
 	}
 	return result
 }
 
 // pageChunk describes first chunk of resource
 type pageChunk struct {
-	data []byte   // first chunk of resource data
-	url  *url.URL // final url resource was fetched from (after all redirects)
-	ct   string   // Content-Type as reported by server
+	data      []byte      // first chunk of resource data, empty if it was not read
+	url       *url.URL    // final url resource was fetched from (after all redirects)
+	ct        string      // Content-Type as reported by server
+	header    http.Header // response headers
+	redirects []string    // urls visited before final one, in order
 }
 
 func (p *pageChunk) oembedEndpoint(fn oembed.LookupFunc) (url string, found bool) {
@@ -413,32 +769,118 @@ func (p *pageChunk) oembedEndpoint(fn oembed.LookupFunc) (url string, found bool
 }
 
 func (h *unfurlHandler) httpGet(ctx context.Context, URL string) (*http.Response, error) {
-	client := h.HTTPClient
+	return h.httpGetWith(ctx, h.HTTPClient, URL)
+}
+
+func (h *unfurlHandler) httpGetWith(ctx context.Context, client *http.Client, URL string) (*http.Response, error) {
 	if client == nil {
 		client = http.DefaultClient
 	}
-	req, err := http.NewRequest(http.MethodGet, URL, nil)
+	req, err := h.newRequest(ctx, http.MethodGet, URL)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// newRequest returns request with extra headers configured with
+// WithExtraHeaders set
+func (h *unfurlHandler) newRequest(ctx context.Context, method, URL string) (*http.Request, error) {
+	req, err := http.NewRequest(method, URL, nil)
 	if err != nil {
 		return nil, err
 	}
 	for i := 0; i < len(h.Headers); i += 2 {
 		req.Header.Set(h.Headers[i], h.Headers[i+1])
 	}
-	req = req.WithContext(ctx)
-	return client.Do(req)
+	return req.WithContext(ctx), nil
 }
 
+// maxRefreshFollows limits number of meta refresh/stub page redirects
+// followed by fetchData, in addition to the client redirect policy
+const maxRefreshFollows = 5
+
 // fetchData fetches the first chunk of the resource. The chunk size is
 // determined by h.MaxBodyChunkSize.
+//
+// If fetched resource is an interstitial page redirecting with meta refresh,
+// or a stub page pointing to its canonical url (see interstitialTarget),
+// fetchData follows such redirect. These redirects count against the redirect
+// policy of h.HTTPClient and are subject to the same blacklist checks as
+// the original url.
 func (h *unfurlHandler) fetchData(ctx context.Context, URL string) (*pageChunk, error) {
-	resp, err := h.httpGet(ctx, URL)
+	client := h.HTTPClient
+	var via []*http.Request // all requests made so far
+	for follows := 0; ; follows++ {
+		cl := client
+		if len(via) > 0 {
+			// make http redirects after meta refresh share the same
+			// redirect budget
+			cl = shareRedirectBudget(client, via)
+		}
+		chunk, reqs, err := h.fetchChunk(ctx, cl, URL)
+		if err != nil {
+			return nil, err
+		}
+		if len(via) > 0 {
+			chunk.redirects = append(redirectURLs(via), chunk.redirects...)
+		}
+		via = append(via, reqs...)
+		if follows == maxRefreshFollows {
+			return chunk, nil
+		}
+		target, ok := interstitialTarget(chunk)
+		if !ok {
+			return chunk, nil
+		}
+		next, err := chunk.url.Parse(target)
+		if err != nil || !validURL(next.String()) || *next == *chunk.url {
+			return chunk, nil
+		}
+		if h.pmap != nil && h.pmap.Match(next.String()) {
+			h.Log.Printf("Blacklisted refresh target %q", next)
+			return chunk, nil
+		}
+		req, err := http.NewRequest(http.MethodGet, next.String(), nil)
+		if err != nil {
+			return chunk, nil
+		}
+		if err := checkRedirect(client, req, via); err != nil {
+			h.Log.Printf("not following refresh to %q: %v", next, err)
+			return chunk, nil
+		}
+		h.Log.Printf("Following refresh from %q to %q", chunk.url, next)
+		URL = next.String()
+	}
+}
+
+// fetchChunk fetches the first chunk of the resource using provided client. It
+// also returns all requests made, including redirected ones, in order. Large
+// binary files are not read, see headChunk and largeBinary.
+func (h *unfurlHandler) fetchChunk(ctx context.Context, client *http.Client, URL string) (*pageChunk, []*http.Request, error) {
+	if isDownloadURL(URL) {
+		if chunk, reqs, ok := h.headChunk(ctx, client, URL); ok {
+			return chunk, reqs, nil
+		}
+	}
+	resp, err := h.httpGetWith(ctx, client, URL)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= http.StatusBadRequest {
-		return nil, errors.New("bad status: " + resp.Status)
+		return nil, nil, errors.New("bad status: " + resp.Status)
+	}
+	reqs := requestChain(resp)
+	if h.largeBinary(resp) {
+		// response body is closed without reading it
+		return &pageChunk{
+			url:       resp.Request.URL,
+			ct:        resp.Header.Get("Content-Type"),
+			header:    resp.Header,
+			redirects: redirectURLs(reqs[:len(reqs)-1]),
+		}, reqs, nil
 	}
 	if resp.Header.Get("Content-Encoding") == "deflate" &&
 		strings.HasSuffix(resp.Request.Host, "twitter.com") {
@@ -447,18 +889,59 @@ func (h *unfurlHandler) fetchData(ctx context.Context, URL string) (*pageChunk,
 		// See https://golang.org/issues/18779 for background
 		var err error
 		if resp.Body, err = zlib.NewReader(resp.Body); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 	head, err := ioutil.ReadAll(io.LimitReader(resp.Body, h.MaxBodyChunkSize))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	return &pageChunk{
-		data: head,
-		url:  resp.Request.URL,
-		ct:   resp.Header.Get("Content-Type"),
-	}, nil
+		data:      head,
+		url:       resp.Request.URL,
+		ct:        resp.Header.Get("Content-Type"),
+		header:    resp.Header,
+		redirects: redirectURLs(reqs[:len(reqs)-1]),
+	}, reqs, nil
+}
+
+// requestChain returns all requests made to receive response, including
+// redirected ones, in order.
+func requestChain(resp *http.Response) []*http.Request {
+	chain := []*http.Request{resp.Request}
+	for r := resp.Request; r.Response != nil && r.Response.Request != nil; r = r.Response.Request {
+		chain = append(chain, r.Response.Request)
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+func redirectURLs(reqs []*http.Request) []string {
+	if len(reqs) == 0 {
+		return nil
+	}
+	out := make([]string, len(reqs))
+	for i, r := range reqs {
+		out[i] = r.URL.String()
+	}
+	return out
+}
+
+// shareRedirectBudget returns copy of the client which redirect policy
+// considers requests already made.
+func shareRedirectBudget(client *http.Client, made []*http.Request) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	cl := *client
+	cl.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		all := make([]*http.Request, 0, len(made)+len(via))
+		all = append(append(all, made...), via...)
+		return checkRedirect(client, req, all)
+	}
+	return &cl
 }
 
 // mcKey returns string of hex representation of sha1 sum of string provided.
@@ -1,11 +1,14 @@
 package unfurlist
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -96,6 +99,150 @@ func TestUnfurlist__multibyteHTML(t *testing.T) {
 	}
 }
 
+func TestUnfurlist__occurrences(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Page</title></head></html>`))
+	}))
+	defer srv.Close()
+	content := srv.URL + "/a, " + srv.URL + "/b and " + srv.URL + "/a again"
+	req := httptest.NewRequest("POST", "/", strings.NewReader("content="+url.QueryEscape(content)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	New().ServeHTTP(w, req)
+	var res []unfurlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 2 {
+		t.Fatalf("unexpected response: %q", w.Body.String())
+	}
+	n := len(srv.URL) + 2
+	want := []linkPosition{
+		{Offset: 0, Length: n, RuneOffset: 0, RuneLength: n},
+		{Offset: 2*n + 7, Length: n, RuneOffset: 2*n + 7, RuneLength: n},
+	}
+	if !reflect.DeepEqual(res[0].Occurrences, want) || res[0].Offset != 0 {
+		t.Errorf("unexpected occurrences of %q: %+v", res[0].URL, res[0].Occurrences)
+	}
+	if len(res[1].Occurrences) != 1 || res[1].Occurrences[0].Offset != res[1].Offset {
+		t.Errorf("unexpected occurrences of %q: %+v", res[1].URL, res[1].Occurrences)
+	}
+}
+
+func TestUnfurlist__redirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/middle", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/middle", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article?id=1", http.StatusFound)
+	})
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Article</title>
+		<link rel="canonical" href="/article"></head></html>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	handler := New(WithRedirectChain(true))
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?content="+srv.URL+"/short", nil)
+	handler.ServeHTTP(w, req)
+	var res []unfurlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+		t.Fatalf("unexpected response: %q", w.Body.String())
+	}
+	if want := srv.URL + "/article?id=1"; res[0].FinalURL != want {
+		t.Errorf("got final url %q, want %q", res[0].FinalURL, want)
+	}
+	if want := srv.URL + "/article"; res[0].CanonicalURL != want {
+		t.Errorf("got canonical url %q, want %q", res[0].CanonicalURL, want)
+	}
+	want := []string{srv.URL + "/short", srv.URL + "/middle"}
+	if len(res[0].Redirects) != len(want) || res[0].Redirects[0] != want[0] || res[0].Redirects[1] != want[1] {
+		t.Errorf("got redirects %q, want %q", res[0].Redirects, want)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		http.Redirect(w, r, "/interstitial", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/interstitial", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><meta http-equiv="Refresh" content="0; url=/final"></head></html>`))
+	})
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	hops, err := Resolve(context.Background(), nil, srv.URL+"/short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Hop{
+		{URL: srv.URL + "/short", Status: http.StatusMovedPermanently},
+		{URL: srv.URL + "/interstitial", Status: http.StatusOK, Refresh: true},
+		{URL: srv.URL + "/final", Status: http.StatusOK},
+	}
+	if !reflect.DeepEqual(hops, want) {
+		t.Fatalf("got hops %+v, want %+v", hops, want)
+	}
+}
+
+func TestUnfurlist__interstitial(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/out", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Redirecting…</title>
+		<meta http-equiv="refresh" content="0;url=/stub"></head></html>`))
+	})
+	mux.HandleFunc("/stub", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Loading</title>
+		<link rel="canonical" href="/page"></head><body>Please wait
+		<script>window.location.replace("/page")</script></body></html>`))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Real page</title></head></html>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var hops int
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		hops = len(via)
+		return nil
+	}}
+	for _, tc := range []struct {
+		h    http.Handler
+		want string
+	}{
+		{New(WithHTTPClient(client)), "Real page"},
+		{New(WithBlacklistPrefixes([]string{srv.URL + "/page"})), "Loading"},
+	} {
+		w := httptest.NewRecorder()
+		tc.h.ServeHTTP(w, httptest.NewRequest("GET", "/?content="+srv.URL+"/out", nil))
+		var res []unfurlResult
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 {
+			t.Fatalf("unexpected response: %q", w.Body.String())
+		}
+		if res[0].Title != tc.want {
+			t.Errorf("got title %q, want %q", res[0].Title, tc.want)
+		}
+	}
+	if hops != 2 {
+		t.Errorf("redirect policy saw %d requests made, want 2", hops)
+	}
+}
+
 func doRequest(url string, t *testing.T) []unfurlResult {
 	pp := newPipePool()
 	defer pp.Close()
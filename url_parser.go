@@ -20,52 +20,59 @@ var reUrls = regexp.MustCompile(`https?://[%:/?#\[\]@!$&'\(\){}*+,;=\pL\pN._~-]+
 func ParseURLs(content string) []string { return parseURLsMax(content, -1) }
 
 func parseURLsMax(content string, maxItems int) []string {
+	spans := uniqueSpans(textSpans(content), maxItems)
+	out := make([]string, len(spans))
+	for i, s := range spans {
+		out[i] = s.url
+	}
+	return out
+}
+
+// textSpans returns spans of all url-like substrings found in plain text
+// content, see ParseURLs for details on how urls are matched.
+func textSpans(content string) []textSpan {
+	idx := reUrls.FindAllStringIndex(content, -1)
+	spans := make([]textSpan, 0, len(idx))
+	for _, m := range idx {
+		s := trimTrailingPunct(content[m[0]:m[1]])
+		spans = append(spans, textSpan{url: s, start: m[0], end: m[0] + len(s)})
+	}
+	return spans
+}
+
+// trimTrailingPunct removes all combinations of trailing []()<>{},;.*_
+// characters from url-like string s, but keeps trailing >]}) if matching
+// opening character is found inside s.
+func trimTrailingPunct(s string) string {
 	const punct = `[]()<>{},;.*_`
-	res := reUrls.FindAllString(content, maxItems)
-	for i, s := range res {
-		// remove all combinations of trailing >)],. characters only if
-		// no similar characters were found somewhere in the middle
-		if idx := strings.IndexAny(s, punct); idx < 0 {
-			continue
+	if strings.IndexAny(s, punct) < 0 {
+		return s
+	}
+	for {
+		idx := strings.LastIndexAny(s, punct)
+		if idx != len(s)-1 {
+			return s
 		}
-	cleanLoop:
-		for {
-			idx2 := strings.LastIndexAny(s, punct)
-			if idx2 != len(s)-1 {
-				break
+		switch s[idx] {
+		case ')':
+			if strings.Index(s, `(`) > 0 {
+				return s
 			}
-			switch s[idx2] {
-			case ')':
-				if strings.Index(s, `(`) > 0 {
-					break cleanLoop
-				}
-			case ']':
-				if strings.Index(s, `[`) > 0 {
-					break cleanLoop
-				}
-			case '>':
-				if strings.Index(s, `<`) > 0 {
-					break cleanLoop
-				}
-			case '}':
-				if strings.Index(s, `{`) > 0 {
-					break cleanLoop
-				}
+		case ']':
+			if strings.Index(s, `[`) > 0 {
+				return s
+			}
+		case '>':
+			if strings.Index(s, `<`) > 0 {
+				return s
+			}
+		case '}':
+			if strings.Index(s, `{`) > 0 {
+				return s
 			}
-			s = s[:idx2]
-		}
-		res[i] = s
-	}
-	out := res[:0]
-	seen := make(map[string]struct{})
-	for _, v := range res {
-		if _, ok := seen[v]; ok {
-			continue
 		}
-		out = append(out, v)
-		seen[v] = struct{}{}
+		s = s[:idx]
 	}
-	return out
 }
 
 // validURL returns true if s is a valid absolute url with http/https scheme.